@@ -49,14 +49,14 @@ func SendChunked(node api.Node, chunkSize uint32, msg api.Msg) (err error) {
 		}
 		b := bytes.NewBuffer(streamID)                            // StreamID
 		binary.Write(b, binary.LittleEndian, uint32(totalChunks)) // NumChunks
-		if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, Chunked: true, StreamHeader: true}); err != nil {
+		if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, HopLimit: msg.HopLimit, Expires: msg.Expires, Priority: msg.Priority, Chunked: true, StreamHeader: true}); err != nil {
 			return
 		}
 		for i := uint32(0); i < wholeLoops; i++ {
 			b := bytes.NewBuffer(streamID)                  // StreamID
 			binary.Write(b, binary.LittleEndian, uint32(i)) // ChunkNum
 			b.Write(buf[i*chunkSizeMinusHeader : (i*chunkSizeMinusHeader)+chunkSizeMinusHeader])
-			if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, Chunked: true}); err != nil {
+			if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, HopLimit: msg.HopLimit, Expires: msg.Expires, Priority: msg.Priority, Chunked: true}); err != nil {
 				return
 			}
 		}
@@ -64,7 +64,7 @@ func SendChunked(node api.Node, chunkSize uint32, msg api.Msg) (err error) {
 			b := bytes.NewBuffer(streamID)                           // StreamID
 			binary.Write(b, binary.LittleEndian, uint32(wholeLoops)) // ChunkNum
 			b.Write(buf[wholeLoops*chunkSizeMinusHeader:])
-			if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, Chunked: true}); err != nil {
+			if err = node.SendMsg(api.Msg{Name: msg.Name, Content: b, IsChan: msg.IsChan, PubKey: msg.PubKey, HopLimit: msg.HopLimit, Expires: msg.Expires, Priority: msg.Priority, Chunked: true}); err != nil {
 				return
 			}
 		}
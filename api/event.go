@@ -1,5 +1,7 @@
 package api
 
+import "time"
+
 // LogLevel - Severity value for events
 type LogLevel int
 
@@ -17,7 +19,26 @@ type EventType int
 
 //
 const (
+	// Log - a log message, Data holds its arguments
 	Log EventType = iota
+	// Delivery - the status of a delivery receipt changed, Payload is a Receipt
+	Delivery
+	// MessageReceived - a message was decrypted and passed to Out(), Payload is a MessageEvent
+	MessageReceived
+	// MessageForwarded - a message was queued in the outbox for other nodes, Payload is a MessageEvent
+	MessageForwarded
+	// PeerPolled - a policy exchanged messages with a peer, Payload is a PeerEvent
+	PeerPolled
+	// PeerFailed - a policy could not exchange messages with a peer, Payload is a PeerEvent
+	PeerFailed
+	// StreamCompleted - all chunks of a stream arrived and were reassembled, Payload is a StreamEvent
+	StreamCompleted
+	// PolicyStarted - a connection policy started running, Payload is a PolicyEvent
+	PolicyStarted
+	// PolicyStopped - a connection policy stopped running, Payload is a PolicyEvent
+	PolicyStopped
+	// MessageDropped - the router discarded a message, Payload is a DropEvent
+	MessageDropped
 )
 
 // Event - Ratnet Events
@@ -25,4 +46,48 @@ type Event struct {
 	Severity LogLevel
 	Type     EventType
 	Data     []interface{}
+	Payload  interface{}
+}
+
+// MessageEvent : payload of MessageReceived and MessageForwarded events
+type MessageEvent struct {
+	Channel string // "" for direct messages
+	IsChan  bool
+	Size    int
+}
+
+// PeerEvent : payload of PeerPolled and PeerFailed events
+type PeerEvent struct {
+	Host    string
+	Err     error // nil for PeerPolled
+	Latency time.Duration
+	BytesTX int64 // bytes sent to the peer by this poll
+	BytesRX int64 // bytes received from the peer by this poll
+}
+
+// StreamEvent : payload of StreamCompleted events
+type StreamEvent struct {
+	StreamID  uint32
+	Channel   string
+	NumChunks uint32
+}
+
+// PolicyEvent : payload of PolicyStarted and PolicyStopped events
+type PolicyEvent struct {
+	Policy    string // the registered name of the policy type
+	Transport string // the registered name of its transport type
+}
+
+// Reasons a router drops a message, used in DropEvent
+const (
+	DropMalformed = "malformed" // the message could not be parsed
+	DropReplay    = "replay"    // loop detection has seen the message before
+	DropHopLimit  = "hop_limit" // the message ran out of hops before it could be forwarded
+	DropFiltered  = "filtered"  // a Filter in a ChainRouter rejected the message
+)
+
+// DropEvent : payload of MessageDropped events
+type DropEvent struct {
+	Reason  string
+	Channel string
 }
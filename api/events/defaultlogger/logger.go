@@ -2,14 +2,15 @@ package defaultlogger
 
 import (
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
 	"github.com/fatih/color"
 )
 
 // StartDefaultLogger - Prints events above the given threshold using log
 func StartDefaultLogger(node api.Node, logLevel api.LogLevel) {
+	sub := events.Subscribe(node, logLevel, api.Log)
 	go func() {
-		event := <-node.Events()
-		if event.Severity >= logLevel && event.Type == api.Log {
+		for event := range sub.Events() {
 			switch event.Severity {
 			case api.Info:
 				c := color.New(color.FgCyan)
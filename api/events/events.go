@@ -0,0 +1,135 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// SubscriptionBufferSize - how many events a Subscription holds before it starts dropping them
+var SubscriptionBufferSize = 256
+
+// Emit - Publishes a typed event with the given payload, in every build,
+// unlike the log messages of Info, Debug, Warning and Error which need the debug build tag
+func Emit(node api.Node, severity api.LogLevel, eventType api.EventType, payload interface{}) {
+	Publish(node, api.Event{Severity: severity, Type: eventType, Payload: payload})
+}
+
+// Subscription - a filtered stream of the events of one node
+type Subscription struct {
+	node        api.Node
+	minSeverity api.LogLevel
+	types       map[api.EventType]bool // nil for all types
+	ch          chan api.Event
+
+	mtx     sync.Mutex
+	closed  bool
+	dropped uint64
+}
+
+var (
+	subsMtx sync.RWMutex
+	subs    = make(map[api.Node][]*Subscription)
+)
+
+// Subscribe - Returns a Subscription to the events of a node at or above minSeverity,
+// limited to the given types if any are given
+func Subscribe(node api.Node, minSeverity api.LogLevel, types ...api.EventType) *Subscription {
+	s := &Subscription{node: node, minSeverity: minSeverity, ch: make(chan api.Event, SubscriptionBufferSize)}
+	if len(types) > 0 {
+		s.types = make(map[api.EventType]bool)
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	subsMtx.Lock()
+	subs[node] = append(subs[node], s)
+	subsMtx.Unlock()
+	return s
+}
+
+// Events : Returns the channel events are delivered on, it is closed by Close
+func (s *Subscription) Events() <-chan api.Event {
+	return s.ch
+}
+
+// Dropped : Returns how many events were discarded because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.dropped
+}
+
+// Close : Stops delivery and closes the events channel
+func (s *Subscription) Close() {
+	subsMtx.Lock()
+	var list []*Subscription // copied, Publish may be iterating over the old one
+	for _, other := range subs[s.node] {
+		if other != s {
+			list = append(list, other)
+		}
+	}
+	if len(list) == 0 {
+		delete(subs, s.node)
+	} else {
+		subs[s.node] = list
+	}
+	subsMtx.Unlock()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+func (s *Subscription) matches(e api.Event) bool {
+	return e.Severity >= s.minSeverity && (s.types == nil || s.types[e.Type])
+}
+
+func (s *Subscription) deliver(e api.Event) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- e:
+	default:
+		s.dropped++
+	}
+}
+
+// Publish - Delivers an event to every matching Subscription of the node,
+// and to the node's Events() channel if something is waiting on it
+func Publish(node api.Node, e api.Event) {
+	subsMtx.RLock()
+	list := subs[node]
+	subsMtx.RUnlock()
+	for _, s := range list {
+		if s.matches(e) {
+			s.deliver(e)
+		}
+	}
+	sendEvent(node, e)
+}
+
+// sendEvent - non-blocking send on the node's own channel, which some nodes close on Stop
+func sendEvent(node api.Node, e api.Event) {
+	if node == nil {
+		return
+	}
+	defer func() {
+		// a send on the channel closed by Stop is expected, anything else is not
+		if r := recover(); r != nil {
+			if err, ok := r.(error); !ok || err.Error() != "send on closed channel" {
+				panic(r)
+			}
+		}
+	}()
+	select {
+	case node.Events() <- e:
+	default:
+	}
+}
@@ -0,0 +1,76 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/awgh/ratnet/api"
+)
+
+type eventNode struct {
+	api.Node // only Events() is used
+	events   chan api.Event
+}
+
+func (n *eventNode) Events() chan api.Event {
+	return n.events
+}
+
+func Test_Subscribe_1(t *testing.T) {
+
+	node := &eventNode{events: make(chan api.Event)}
+	all := Subscribe(node, api.Info)
+	peers := Subscribe(node, api.Warning, api.PeerFailed)
+	defer all.Close()
+
+	Publish(node, api.Event{Severity: api.Info, Type: api.Log, Data: []interface{}{"hello"}})
+	Emit(node, api.Debug, api.PeerPolled, api.PeerEvent{Host: "a"})
+	Emit(node, api.Warning, api.PeerFailed, api.PeerEvent{Host: "b"})
+
+	if n := len(all.Events()); n != 3 {
+		t.Fatalf("expected 3 events, got %d", n)
+	}
+	if n := len(peers.Events()); n != 1 {
+		t.Fatalf("expected 1 filtered event, got %d", n)
+	}
+	e := <-peers.Events()
+	if p, ok := e.Payload.(api.PeerEvent); !ok || p.Host != "b" {
+		t.Fatalf("unexpected payload %+v", e.Payload)
+	}
+
+	peers.Close()
+	Emit(node, api.Warning, api.PeerFailed, api.PeerEvent{Host: "c"})
+	if _, ok := <-peers.Events(); ok {
+		t.Fatal("closed subscription received an event")
+	}
+}
+
+func Test_Subscribe_Dropped_1(t *testing.T) {
+
+	defer func(n int) { SubscriptionBufferSize = n }(SubscriptionBufferSize)
+	SubscriptionBufferSize = 2
+
+	node := &eventNode{events: make(chan api.Event)}
+	sub := Subscribe(node, api.Info, api.MessageReceived)
+	defer sub.Close()
+	for i := 0; i < 5; i++ {
+		Emit(node, api.Info, api.MessageReceived, api.MessageEvent{Size: i})
+	}
+	if d := sub.Dropped(); d != 3 {
+		t.Fatalf("expected 3 dropped events, got %d", d)
+	}
+}
+
+func Test_Publish_Closed_1(t *testing.T) {
+
+	node := &eventNode{events: make(chan api.Event, 1)}
+	close(node.events) // as by Stop
+	Emit(node, api.Info, api.MessageReceived, api.MessageEvent{})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("a panic other than a send on a closed channel was swallowed")
+		}
+	}()
+	Emit(&eventNode{}, api.Info, api.MessageReceived, api.MessageEvent{}) // nil channel is fine
+	Emit(new(struct{ api.Node }), api.Info, api.MessageReceived, api.MessageEvent{})
+}
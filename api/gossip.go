@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/ratnet/api/xeddsa"
+)
+
+// SharedPeerGroup : peers in this group are the ones a node hands out through GetPeerList
+const SharedPeerGroup = "shared"
+
+// Signer : a KeyPair that can sign data, for key types other than ecc, which Sign supports itself
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier : a PubKey that can check signatures made with its private half, for key types other than ecc
+type Verifier interface {
+	Verify(data []byte, signature []byte) bool
+}
+
+// Errors for data that could not be signed or checked, rather than failed the check
+var (
+	ErrUnsigned     = errors.New("Peer list is not signed")
+	ErrCannotSign   = errors.New("Key type cannot sign")
+	ErrCannotVerify = errors.New("Key type cannot verify signatures")
+)
+
+// Sign : Signs data with a routing key, ErrCannotSign if its key type cannot sign
+func Sign(key bc.KeyPair, data []byte) ([]byte, error) {
+	switch k := key.(type) {
+	case Signer:
+		return k.Sign(data)
+	case *ecc.KeyPair:
+		// an ecc key pair is exported as its X25519 public key followed by its private key
+		b, err := base64.StdEncoding.DecodeString(k.ToB64())
+		if err != nil || len(b) != 64 {
+			return nil, ErrCannotSign
+		}
+		sig, err := xeddsa.Sign(b[32:], k.GetPubKey().ToBytes(), data)
+		if err != nil {
+			return nil, ErrCannotSign
+		}
+		return sig, nil
+	}
+	return nil, ErrCannotSign
+}
+
+// Verify : Returns whether signature is a valid signature of data by the private half of pub,
+// ErrCannotVerify if its key type cannot check signatures
+func Verify(pub bc.PubKey, data []byte, signature []byte) (bool, error) {
+	switch k := pub.(type) {
+	case Verifier:
+		return k.Verify(data, signature), nil
+	case *ecc.PubKey:
+		return xeddsa.Verify(k.ToBytes(), data, signature), nil
+	}
+	return false, ErrCannotVerify
+}
+
+// PeerAd : one peer in a gossiped peer list
+type PeerAd struct {
+	Name string
+	URI  string
+	// Transport - the name of the transport that reaches URI, "" for the one the list was fetched over
+	Transport string
+}
+
+// PeerList : the peers a node shares with others, as returned by GetPeerList
+type PeerList struct {
+	Origin    string // routing public key of the node that made the list, base64
+	Time      int64  // when the list was made, UnixNano
+	Peers     []PeerAd
+	Signature []byte // over everything above, empty if the routing key cannot sign
+}
+
+// NewPeerList : Returns an unsigned list of the enabled peers, made now by the node with the given routing key
+func NewPeerList(origin bc.PubKey, peers []Peer) *PeerList {
+	list := &PeerList{Origin: origin.ToB64(), Time: time.Now().UnixNano()}
+	for _, p := range peers {
+		if !p.Enabled {
+			continue
+		}
+		ad := PeerAd{Name: p.Name, URI: p.URI}
+		if i := strings.Index(p.URI, "://"); i > 0 {
+			ad.Transport, ad.URI = p.URI[:i], p.URI[i+3:]
+		}
+		list.Peers = append(list.Peers, ad)
+	}
+	return list
+}
+
+// Sign : Signs the list with the given routing key, it is left unsigned on an error such as ErrCannotSign
+func (l *PeerList) Sign(key bc.KeyPair) error {
+	l.Signature = nil
+	sig, err := Sign(key, l.signedBytes())
+	if err != nil {
+		return err
+	}
+	l.Signature = sig
+	return nil
+}
+
+// Verify : Checks that the list was signed by the private half of pub, and that pub is its Origin
+func (l *PeerList) Verify(pub bc.PubKey) error {
+	if pub.ToB64() != l.Origin {
+		return errors.New("Peer list origin does not match the key")
+	}
+	if len(l.Signature) == 0 {
+		return ErrUnsigned
+	}
+	valid, err := Verify(pub, l.signedBytes(), l.Signature)
+	if err != nil {
+		return err
+	} else if !valid {
+		return errors.New("Peer list signature is invalid")
+	}
+	return nil
+}
+
+// signedBytes - the serialized list without its signature
+func (l *PeerList) signedBytes() []byte {
+	b := bytes.NewBuffer([]byte{})
+	writeLV(b, []byte(l.Origin))
+	binary.Write(b, binary.BigEndian, l.Time)
+	for _, ad := range l.Peers {
+		writeLV(b, []byte(ad.Name))
+		writeLV(b, []byte(ad.URI))
+		writeLV(b, []byte(ad.Transport))
+	}
+	return b.Bytes()
+}
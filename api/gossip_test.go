@@ -0,0 +1,42 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/bencrypt/rsa"
+)
+
+func Test_Sign_Ecc_1(t *testing.T) {
+	key := new(ecc.KeyPair)
+	if err := key.FromB64("Tcksa18txiwMEocq7NXdeMwz6PPBD+nxCjb/WCtxq1+dln3M3IaOmg+YfTIbBpk+jIbZZZiT+4CoeFzaJGEWmg=="); err != nil {
+		t.Fatal(err)
+	}
+	pub := new(ecc.PubKey)
+	if err := pub.FromB64("Tcksa18txiwMEocq7NXdeMwz6PPBD+nxCjb/WCtxq18="); err != nil {
+		t.Fatal(err)
+	}
+	list := NewPeerList(key.GetPubKey(), []Peer{{Name: "a", URI: "https://10.0.0.1:20001", Enabled: true}})
+	if err := list.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Signature) == 0 {
+		t.Fatal("ecc routing key did not sign the list")
+	}
+	if err := list.Verify(pub); err != nil {
+		t.Fatal("signed list did not verify:", err)
+	}
+	list.Peers[0].URI = "https://10.6.6.6:20001"
+	if err := list.Verify(pub); err == nil {
+		t.Fatal("altered list verified")
+	}
+
+	other := new(ecc.KeyPair)
+	other.GenerateKey()
+	if ok, err := Verify(other.GetPubKey(), []byte("data"), list.Signature); ok || err != nil {
+		t.Fatal("signature verified for another key:", ok, err)
+	}
+	if _, err := Sign(new(rsa.KeyPair), []byte("data")); err != ErrCannotSign {
+		t.Fatal("expected ErrCannotSign for an rsa key, got", err)
+	}
+}
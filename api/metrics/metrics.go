@@ -0,0 +1,247 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// Path - where Listen serves the metrics
+const Path = "/metrics"
+
+// Collector - counts the events of a node and serves them, along with its queue sizes,
+// in the Prometheus text exposition format
+type Collector struct {
+	node api.Node
+	sub  *events.Subscription
+	done chan struct{}
+
+	mtx       sync.Mutex
+	peers     map[string]*peerMetrics
+	drops     map[string]uint64 // by DropEvent Reason
+	policies  map[string]int    // running policies by name
+	received  uint64
+	forwarded uint64
+	streams   uint64
+
+	server *http.Server
+}
+
+type peerMetrics struct {
+	polls    uint64
+	failures uint64
+	bytesTX  int64
+	bytesRX  int64
+	latency  time.Duration // total over all polls, successful or not
+}
+
+// NewCollector - returns a new Collector counting the events of the given node from now on
+func NewCollector(node api.Node) *Collector {
+	c := new(Collector)
+	c.node = node
+	c.peers = make(map[string]*peerMetrics)
+	c.drops = make(map[string]uint64)
+	c.policies = make(map[string]int)
+	c.done = make(chan struct{})
+	c.sub = events.Subscribe(node, api.Info, api.MessageReceived, api.MessageForwarded, api.MessageDropped,
+		api.PeerPolled, api.PeerFailed, api.StreamCompleted, api.PolicyStarted, api.PolicyStopped)
+	go func() {
+		defer close(c.done)
+		for e := range c.sub.Events() {
+			c.handle(e)
+		}
+	}()
+	return c
+}
+
+// Listen : Serves the metrics over HTTP on the given local address, at Path
+func (c *Collector) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, c)
+	server := &http.Server{Handler: mux}
+	c.mtx.Lock()
+	c.server = server
+	c.mtx.Unlock()
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			events.Error(c.node, "metrics endpoint stopped: "+err.Error())
+		}
+	}()
+	return nil
+}
+
+// Stop : Stops counting events and closes the HTTP endpoint, if any
+func (c *Collector) Stop() {
+	c.sub.Close()
+	<-c.done
+	c.mtx.Lock()
+	server := c.server
+	c.server = nil
+	c.mtx.Unlock()
+	if server != nil {
+		server.Close()
+	}
+}
+
+// ServeHTTP : Writes the metrics in the Prometheus text exposition format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.WriteTo(w)
+}
+
+func (c *Collector) handle(e api.Event) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	switch p := e.Payload.(type) {
+	case api.PeerEvent:
+		peer, ok := c.peers[p.Host]
+		if !ok {
+			peer = new(peerMetrics)
+			c.peers[p.Host] = peer
+		}
+		if e.Type == api.PeerFailed {
+			peer.failures++
+		} else {
+			peer.polls++
+		}
+		peer.bytesTX += p.BytesTX
+		peer.bytesRX += p.BytesRX
+		peer.latency += p.Latency
+	case api.MessageEvent:
+		if e.Type == api.MessageReceived {
+			c.received++
+		} else {
+			c.forwarded++
+		}
+	case api.DropEvent:
+		c.drops[p.Reason]++
+	case api.StreamEvent:
+		c.streams++
+	case api.PolicyEvent:
+		if e.Type == api.PolicyStarted {
+			c.policies[p.Policy]++
+		} else if c.policies[p.Policy] > 0 {
+			c.policies[p.Policy]--
+		}
+	}
+}
+
+// WriteTo : Writes the metrics in the Prometheus text exposition format
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	out := &countingWriter{w: bufio.NewWriter(w)}
+
+	c.mtx.Lock()
+	hosts := make([]string, 0, len(c.peers))
+	for host := range c.peers {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	peerCounter := func(name, help string, value func(*peerMetrics) string) {
+		out.header(name, help, "counter")
+		for _, host := range hosts {
+			out.printf("%s{peer=\"%s\"} %s\n", name, escape(host), value(c.peers[host]))
+		}
+	}
+	peerCounter("ratnet_peer_polls_total", "Successful polls of a peer.",
+		func(p *peerMetrics) string { return fmt.Sprint(p.polls) })
+	peerCounter("ratnet_peer_poll_failures_total", "Failed polls of a peer.",
+		func(p *peerMetrics) string { return fmt.Sprint(p.failures) })
+	peerCounter("ratnet_peer_sent_bytes_total", "Bytes sent to a peer.",
+		func(p *peerMetrics) string { return fmt.Sprint(p.bytesTX) })
+	peerCounter("ratnet_peer_received_bytes_total", "Bytes received from a peer.",
+		func(p *peerMetrics) string { return fmt.Sprint(p.bytesRX) })
+	out.header("ratnet_peer_poll_duration_seconds", "Time spent polling a peer.", "summary")
+	for _, host := range hosts {
+		p := c.peers[host]
+		out.printf("ratnet_peer_poll_duration_seconds_sum{peer=\"%s\"} %g\n", escape(host), p.latency.Seconds())
+		out.printf("ratnet_peer_poll_duration_seconds_count{peer=\"%s\"} %d\n", escape(host), p.polls+p.failures)
+	}
+
+	out.metric("ratnet_messages_received_total", "Messages delivered to the Out channel.", "counter", c.received)
+	out.metric("ratnet_messages_forwarded_total", "Messages queued in the outbox for other nodes.", "counter", c.forwarded)
+	out.metric("ratnet_streams_completed_total", "Chunked streams reassembled.", "counter", c.streams)
+
+	reasons := make([]string, 0, len(c.drops))
+	for reason := range c.drops {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	out.header("ratnet_router_dropped_total", "Messages discarded by the router.", "counter")
+	for _, reason := range reasons {
+		out.printf("ratnet_router_dropped_total{reason=\"%s\"} %d\n", escape(reason), c.drops[reason])
+	}
+
+	names := make([]string, 0, len(c.policies))
+	for name := range c.policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out.header("ratnet_policies_running", "Connection policies currently running.", "gauge")
+	for _, name := range names {
+		out.printf("ratnet_policies_running{policy=\"%s\"} %d\n", escape(name), c.policies[name])
+	}
+	c.mtx.Unlock()
+
+	out.metric("ratnet_events_dropped_total", "Events the metrics collector could not keep up with.", "counter", c.sub.Dropped())
+
+	if q, ok := c.node.(api.QueueReporter); ok {
+		if stats, err := q.QueueStats(); err == nil {
+			out.metric("ratnet_outbox_messages", "Messages waiting in the outbox.", "gauge", stats.OutboxMsgs)
+			out.metric("ratnet_outbox_bytes", "Size of the messages waiting in the outbox.", "gauge", stats.OutboxBytes)
+			out.metric("ratnet_streams_pending", "Chunked streams waiting for reassembly.", "gauge", stats.Streams)
+			out.metric("ratnet_stream_chunks_pending", "Chunks received for streams waiting for reassembly.", "gauge", stats.Chunks)
+		} else {
+			events.Warning(c.node, "metrics could not read queue sizes: "+err.Error())
+		}
+	}
+
+	if out.err == nil {
+		out.err = out.w.Flush()
+	}
+	return out.n, out.err
+}
+
+// countingWriter - keeps the first error and the number of bytes written, for WriteTo
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+func (cw *countingWriter) header(name, help, kind string) {
+	cw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (cw *countingWriter) metric(name, help, kind string, value interface{}) {
+	cw.header(name, help, kind)
+	cw.printf("%s %v\n", name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape - escapes a label value for the text exposition format
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
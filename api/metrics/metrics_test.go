@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes/ram"
+)
+
+func Test_Collector_1(t *testing.T) {
+
+	node := ram.New(nil, nil)
+	c := NewCollector(node)
+	defer c.Stop()
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	events.Emit(node, api.Debug, api.PeerPolled, api.PeerEvent{Host: "https://a:20001", Latency: time.Second, BytesTX: 10, BytesRX: 20})
+	events.Emit(node, api.Warning, api.PeerFailed, api.PeerEvent{Host: "https://a:20001", Latency: time.Second, Err: errors.New("refused")})
+	events.Emit(node, api.Debug, api.MessageDropped, api.DropEvent{Reason: api.DropReplay})
+	events.Emit(node, api.Info, api.MessageReceived, api.MessageEvent{Size: 5})
+	events.Emit(node, api.Info, api.PolicyStarted, api.PolicyEvent{Policy: "poll"})
+
+	expected := []string{
+		`ratnet_peer_polls_total{peer="https://a:20001"} 1`,
+		`ratnet_peer_poll_failures_total{peer="https://a:20001"} 1`,
+		`ratnet_peer_sent_bytes_total{peer="https://a:20001"} 10`,
+		`ratnet_peer_received_bytes_total{peer="https://a:20001"} 20`,
+		`ratnet_peer_poll_duration_seconds_sum{peer="https://a:20001"} 2`,
+		`ratnet_peer_poll_duration_seconds_count{peer="https://a:20001"} 2`,
+		`ratnet_router_dropped_total{reason="replay"} 1`,
+		`ratnet_messages_received_total 1`,
+		`ratnet_policies_running{policy="poll"} 1`,
+		`ratnet_outbox_messages 0`,
+		`# TYPE ratnet_streams_pending gauge`,
+	}
+	// events are counted in the background, the last one emitted is the last one counted
+	var out string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(server.URL + Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out = string(b); strings.Contains(out, expected[8]+"\n") {
+			break
+		}
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+func Test_escape_1(t *testing.T) {
+	if s := escape("a\"b\\c\nd"); s != `a\"b\\c\nd` {
+		t.Fatal("bad escape:", s)
+	}
+}
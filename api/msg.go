@@ -14,4 +14,33 @@ type Msg struct {
 	PubKey       bc.PubKey
 	Chunked      bool
 	StreamHeader bool
+	// HopLimit - how many more nodes may forward this message, 0 for no limit
+	HopLimit uint8
+	// Expires - UnixNano time after which this message is dropped from the outbox, 0 for never
+	Expires int64
+	// Priority - messages with a higher priority are picked up from the outbox first, 0 is normal
+	Priority uint8
+	// ReceiptID - set on a direct message to ask the recipient for a delivery receipt identified by it
+	ReceiptID []byte
+	// Receipt - the content begins with a receipt header: a request, a receipt, or none (the ReceiptFlag on the wire)
+	Receipt bool
+}
+
+// ReceiptStatus - delivery state of a message sent with a ReceiptID
+type ReceiptStatus int
+
+// A receipt is Pending until the recipient acknowledges it, or it Expires
+const (
+	ReceiptPending ReceiptStatus = iota
+	ReceiptDelivered
+	ReceiptExpired
+)
+
+// Receipt : delivery status of a direct message sent with a ReceiptID
+type Receipt struct {
+	ID        []byte
+	Status    ReceiptStatus
+	Sent      int64 // UnixNano
+	Delivered int64 // UnixNano, 0 until delivered
+	Expires   int64 // UnixNano
 }
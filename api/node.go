@@ -12,12 +12,21 @@ type Node interface {
 	Stop()
 	GetPolicies() []Policy
 	SetPolicy(policies ...Policy)
+	// AddPolicy : Adds a Policy, starting it if the node is running
+	AddPolicy(policy Policy) error
+	// RemovePolicy : Stops a Policy and removes it
+	RemovePolicy(policy Policy) error
 	Router() Router
 	SetRouter(router Router)
 	GetChannelPrivKey(name string) (string, error)
 	Handle(msg Msg) (bool, error)
 	Forward(msg Msg) error
 
+	// GetReceipt : Returns the delivery status of a message sent with the given ReceiptID, nil if unknown
+	GetReceipt(id []byte) (*Receipt, error)
+	// SetPeerStats : Replaces the poll statistics kept for stats.Host
+	SetPeerStats(stats PeerStats) error
+
 	// Chunking
 	// AddStream - inform node of receipt of a stream header
 	AddStream(streamID uint32, totalChunks uint32, channelName string) error
@@ -47,6 +56,9 @@ type Node interface {
 	// Pickup : Get outgoing messages from this node (3)
 	Pickup(routingPub bc.PubKey, lastTime int64, maxBytes int64, channelNames ...string) (Bundle, error)
 
+	// GetPeerList : Get the peers in SharedPeerGroup, signed with the routing key if it can sign (4)
+	GetPeerList() (*PeerList, error)
+
 	//
 
 	// Admin API Functions
@@ -101,6 +113,11 @@ type Node interface {
 	// SendMsg : Transmit a message object (36)
 	SendMsg(msg Msg) error
 
+	// GetPeerStats : Return the poll statistics of the given peer hosts, or of all of them if none are given (37)
+	GetPeerStats(host ...string) ([]PeerStats, error)
+	// ResetPeerStats : Forget the poll statistics of the given peer hosts, or of all of them if none are given (38)
+	ResetPeerStats(host ...string) error
+
 	//  End of Admin API Functions
 
 	//
@@ -118,6 +135,26 @@ type Node interface {
 	SetDebug(mode bool)
 }
 
+// QueueReporter : a Node that can report how much work is waiting in its queues
+type QueueReporter interface {
+	// QueueStats : Returns the current outbox and stream reassembly backlog
+	QueueStats() (QueueStats, error)
+}
+
+// RoutingSigner : a Node that can sign data with its routing key, so peers holding its ID can authenticate it
+type RoutingSigner interface {
+	// SignRouting : Returns a signature of data made with the routing key, ErrCannotSign if the key type cannot sign
+	SignRouting(data []byte) ([]byte, error)
+}
+
+// QueueStats : sizes of the queues of a node
+type QueueStats struct {
+	OutboxMsgs  int   // messages waiting in the outbox, including expired ones not yet flushed
+	OutboxBytes int64 // total size of those messages
+	Streams     int   // stream headers waiting for their chunks
+	Chunks      int   // chunks received for those streams
+}
+
 // Contact : object that describes a contact (named public key)
 type Contact struct {
 	Name   string `db:"name"`
@@ -183,6 +220,8 @@ type OutboxMsg struct {
 	Channel   string `db:"channel"`
 	Msg       []byte `db:"msg"`
 	Timestamp int64  `db:"timestamp"`
+	Expires   int64  `db:"expires"` // UnixNano deadline, 0 for none
+	Priority  uint8  `db:"priority"`
 }
 
 // ConfigValue - Name/Value pairs of configuration strings
@@ -0,0 +1,80 @@
+package api
+
+import "sort"
+
+// PickupEntry : what Pickup needs to know about an outbox message newer than lastTime to decide whether to send it
+type PickupEntry struct {
+	Size      int64
+	Timestamp int64
+	Priority  uint8
+}
+
+// PackPickup : chooses which outbox messages go in a Pickup bundle of at most maxBytes (0 for no limit),
+// highest Priority first, then oldest first, skipping any that do not fit in the space left.
+// Room is always kept for the oldest message that fits in a bundle, so the cursor moves on every call
+// and higher priority messages can not hold an older one back forever.
+// Returns the indices of the chosen entries in the order they should be sent,
+// the new lastTime cursor, and whether some entry can never fit in a bundle of this size.
+// The cursor is the newest timestamp with nothing at or before it left behind,
+// so a message sent ahead of an older one that did not fit may be sent again (loop detection drops the copy).
+func PackPickup(entries []PickupEntry, lastTime, maxBytes int64) (chosen []int, cursor int64, tooBig bool) {
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ea, eb := entries[order[a]], entries[order[b]]
+		if ea.Priority != eb.Priority {
+			return ea.Priority > eb.Priority
+		}
+		return ea.Timestamp < eb.Timestamp
+	})
+
+	oldest := -1
+	for i, e := range entries {
+		if (maxBytes <= 0 || e.Size <= maxBytes) && (oldest < 0 || e.Timestamp < entries[oldest].Timestamp) {
+			oldest = i
+		}
+	}
+	var total int64
+	if oldest >= 0 {
+		total = entries[oldest].Size
+	}
+	left := false
+	var oldestLeft int64
+	for _, i := range order {
+		e := entries[i]
+		if i == oldest { // its room is already counted
+			chosen = append(chosen, i)
+			continue
+		}
+		if maxBytes > 0 && e.Size > maxBytes {
+			tooBig = true // would hold the cursor back forever, so it does not count as left behind
+			continue
+		}
+		if maxBytes > 0 && total+e.Size > maxBytes {
+			if !left || e.Timestamp < oldestLeft {
+				oldestLeft = e.Timestamp
+			}
+			left = true
+			continue
+		}
+		total += e.Size
+		chosen = append(chosen, i)
+	}
+
+	cursor = lastTime
+	for _, i := range chosen {
+		if ts := entries[i].Timestamp; ts > cursor && (!left || ts < oldestLeft) {
+			cursor = ts
+		}
+	}
+	if !left { // also move past any messages too big to ever send
+		for _, e := range entries {
+			if e.Timestamp > cursor {
+				cursor = e.Timestamp
+			}
+		}
+	}
+	return chosen, cursor, tooBig
+}
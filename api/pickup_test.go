@@ -0,0 +1,51 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_PackPickup_1(t *testing.T) {
+	entries := []PickupEntry{
+		{Size: 60, Timestamp: 10},              // 0: old bulk
+		{Size: 60, Timestamp: 20},              // 1: newer bulk
+		{Size: 10, Timestamp: 30, Priority: 5}, // 2: control message
+		{Size: 10, Timestamp: 40},              // 3: small, fits after the first bulk
+	}
+	chosen, cursor, tooBig := PackPickup(entries, 0, 100)
+	if !reflect.DeepEqual(chosen, []int{2, 0, 3}) {
+		t.Fatal("wrong packing order:", chosen)
+	}
+	if cursor != 10 || tooBig { // entry 1 was left behind, so the cursor can't move past it
+		t.Fatal("wrong cursor:", cursor, tooBig)
+	}
+
+	chosen, cursor, _ = PackPickup(entries, 0, 0)
+	if len(chosen) != 4 || cursor != 40 {
+		t.Fatal("unlimited pickup did not take everything:", chosen, cursor)
+	}
+
+	chosen, cursor, tooBig = PackPickup([]PickupEntry{{Size: 500, Timestamp: 7}, {Size: 5, Timestamp: 8}}, 3, 100)
+	if !reflect.DeepEqual(chosen, []int{1}) || cursor != 8 || !tooBig {
+		t.Fatal("oversized message was not skipped:", chosen, cursor, tooBig)
+	}
+
+	// a priority message must not keep an older one that no longer fits from ever being sent
+	entries = []PickupEntry{
+		{Size: 60, Timestamp: 20, Priority: 5},
+		{Size: 60, Timestamp: 10},
+	}
+	chosen, cursor, _ = PackPickup(entries, 0, 100)
+	if !reflect.DeepEqual(chosen, []int{1}) || cursor != 10 {
+		t.Fatal("oldest message was not sent first:", chosen, cursor)
+	}
+	chosen, cursor, _ = PackPickup(entries[:1], cursor, 100)
+	if !reflect.DeepEqual(chosen, []int{0}) || cursor != 20 {
+		t.Fatal("priority message was not sent next:", chosen, cursor)
+	}
+
+	chosen, cursor, _ = PackPickup(nil, 3, 100)
+	if len(chosen) != 0 || cursor != 3 {
+		t.Fatal("empty pickup moved the cursor:", cursor)
+	}
+}
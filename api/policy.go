@@ -8,6 +8,34 @@ type Policy interface {
 	Stop()
 	MarshalJSON() (b []byte, e error)
 	GetTransport() Transport
+	Status() PolicyStatus
+}
+
+// PolicyState : whether a policy is running, as reported by its Status
+type PolicyState byte
+
+// Policy states, StateStopped is the zero value
+const (
+	StateStopped PolicyState = iota
+	StateRunning
+	StateError // stopped by an error, see PolicyStatus.Err
+)
+
+var policyStateNames = []string{"stopped", "running", "error"}
+
+// String : Returns the name of the state
+func (s PolicyState) String() string {
+	if int(s) >= len(policyStateNames) {
+		return "unknown"
+	}
+	return policyStateNames[s]
+}
+
+// PolicyStatus : the state of a policy, returned by its Status and by GetPolicyStatus
+type PolicyStatus struct {
+	Policy string // the registered name of the policy type
+	State  PolicyState
+	Err    string // the error that stopped the policy, when State is StateError
 }
 
 // PeerInfo - last contact info for peers
@@ -18,3 +46,17 @@ type PeerInfo struct {
 	TotalBytesRX   int64
 	RoutingPub     bc.PubKey
 }
+
+// PeerStats - poll statistics a node keeps for each peer host, returned by GetPeerStats
+type PeerStats struct {
+	Host           string `db:"host"`
+	LastPollLocal  int64  `db:"lastpolllocal"`  // time cursor of the last local Pickup sent to the peer
+	LastPollRemote int64  `db:"lastpollremote"` // time cursor of the last remote Pickup from the peer
+	LastSuccess    int64  `db:"lastsuccess"`    // UnixNano of the last successful poll, 0 if never
+	LastFailure    int64  `db:"lastfailure"`    // UnixNano of the last failed poll, 0 if never
+	LastError      string `db:"lasterror"`      // error of the last failed poll
+	Polls          int64  `db:"polls"`
+	Failures       int64  `db:"failures"`
+	TotalBytesTX   int64  `db:"bytestx"`
+	TotalBytesRX   int64  `db:"bytesrx"`
+}
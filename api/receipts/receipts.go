@@ -0,0 +1,274 @@
+package receipts
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+const (
+	kindRequest = 0 // header carries an ID, a secret, and the content key to send the receipt to
+	kindReceipt = 1 // header carries the ID being acknowledged and the secret from its request, there is no other content
+	kindNone    = 2 // header carries an empty ID, the message asks for no receipt
+
+	// secretSize - length of the random secret that only the recipient of a request can read,
+	// so nobody else who knows the ReceiptID and the sender's content key can forge its receipt
+	secretSize = 16
+)
+
+var (
+	// DefaultTimeout - how long a receipt stays pending when the message has no Expires deadline
+	DefaultTimeout = 24 * time.Hour
+	// Retention - how long a delivered or expired receipt can still be queried
+	Retention = time.Hour
+)
+
+// Request - prepends a receipt request header to the content of a direct message
+func Request(node api.Node, msg api.Msg) (api.Msg, error) {
+	if msg.IsChan {
+		return msg, errors.New("Delivery receipts are only supported for direct messages")
+	}
+	if len(msg.ReceiptID) == 0 || len(msg.ReceiptID) > 255 {
+		return msg, errors.New("ReceiptID must be between 1 and 255 bytes")
+	}
+	cid, err := node.CID()
+	if err != nil {
+		return msg, err
+	}
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return msg, err
+	}
+	b := requestHeader(msg.ReceiptID, secret, cid.ToB64())
+	b.Write(msg.Content.Bytes())
+	msg.Content = b
+	msg.Receipt = true
+	return msg, nil
+}
+
+// requestHeader - returns a receipt request header
+func requestHeader(id, secret []byte, key string) *bytes.Buffer {
+	b := bytes.NewBuffer([]byte{kindRequest, byte(len(id))})
+	b.Write(id)
+	b.Write(secret)
+	binary.Write(b, binary.BigEndian, uint16(len(key)))
+	b.WriteString(key)
+	return b
+}
+
+// receiptHeader - returns the receipt answering a request with the given ID and secret
+func receiptHeader(id, secret []byte) *bytes.Buffer {
+	b := bytes.NewBuffer([]byte{kindReceipt, byte(len(id))})
+	b.Write(id)
+	b.Write(secret)
+	return b
+}
+
+// Plain - prepends an empty receipt header to the content of a direct message that asks for no receipt,
+// so relays can not tell from the cleartext flags which direct messages ask for one
+func Plain(msg api.Msg) api.Msg {
+	b := bytes.NewBuffer([]byte{kindNone, 0})
+	b.Write(msg.Content.Bytes())
+	msg.Content = b
+	msg.Receipt = true
+	return msg
+}
+
+// Tracker - keeps the status of the delivery receipts requested by a node
+type Tracker struct {
+	mtx      sync.Mutex
+	node     api.Node
+	receipts map[string]*api.Receipt
+	secrets  map[string][]byte // of the pending receipts, from their requests
+	timers   map[string]*time.Timer
+	stopped  bool
+}
+
+// NewTracker - returns a new Tracker reporting Delivery events to the given node
+func NewTracker(node api.Node) *Tracker {
+	t := new(Tracker)
+	t.node = node
+	t.receipts = make(map[string]*api.Receipt)
+	t.secrets = make(map[string][]byte)
+	t.timers = make(map[string]*time.Timer)
+	return t
+}
+
+// Track : Starts waiting for the receipt of a message that was sent with a ReceiptID,
+// msg must carry the request header added by Request, only a receipt with its secret is accepted
+func (t *Tracker) Track(msg api.Msg) {
+	now := time.Now()
+	r := &api.Receipt{ID: msg.ReceiptID, Status: api.ReceiptPending, Sent: now.UnixNano(), Expires: msg.Expires}
+	if r.Expires == 0 {
+		r.Expires = now.Add(DefaultTimeout).UnixNano()
+	}
+	id := string(msg.ReceiptID)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.stopped {
+		return
+	}
+	t.receipts[id] = r
+	if msg.Content != nil {
+		if b := msg.Content.Bytes(); len(b) >= 2+len(id)+secretSize && b[0] == kindRequest {
+			t.secrets[id] = append([]byte(nil), b[2+len(id):2+len(id)+secretSize]...)
+		}
+	}
+	t.schedule(id, time.Duration(r.Expires-now.UnixNano()), func() {
+		t.finish(id, api.ReceiptExpired)
+	})
+}
+
+// GetReceipt : Returns a copy of the receipt with the given ID, or nil if it is unknown
+func (t *Tracker) GetReceipt(id []byte) (*api.Receipt, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	r, ok := t.receipts[string(id)]
+	if !ok {
+		return nil, nil
+	}
+	receipt := *r
+	return &receipt, nil
+}
+
+// Handle : Processes the receipt header of a decrypted direct message,
+// returns the message with the header removed and whether it should be delivered to the application
+func (t *Tracker) Handle(msg api.Msg) (api.Msg, bool, error) {
+	b := msg.Content.Bytes()
+	if len(b) < 2 || len(b) < 2+int(b[1]) {
+		return msg, false, errors.New("Malformed receipt header")
+	}
+	kind := b[0]
+	id := b[2 : 2+int(b[1])]
+	b = b[2+len(id):]
+
+	switch kind {
+	case kindNone:
+		msg.Content = bytes.NewBuffer(b)
+		return msg, true, nil
+	case kindReceipt:
+		if len(b) != secretSize {
+			return msg, false, errors.New("Malformed receipt header")
+		}
+		t.mtx.Lock()
+		secret, ok := t.secrets[string(id)]
+		t.mtx.Unlock()
+		if !ok || subtle.ConstantTimeCompare(secret, b) != 1 {
+			events.Warning(t.node, "Ignored a receipt without the secret of its request")
+			return msg, false, nil
+		}
+		t.finish(string(id), api.ReceiptDelivered)
+		return msg, false, nil
+	case kindRequest:
+		if len(b) < secretSize+2 {
+			return msg, false, errors.New("Malformed receipt header")
+		}
+		secret := b[:secretSize]
+		b = b[secretSize:]
+		keyLen := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+keyLen {
+			return msg, false, errors.New("Malformed receipt header")
+		}
+		key := string(b[2 : 2+keyLen])
+		msg.Content = bytes.NewBuffer(b[2+keyLen:])
+		if err := t.acknowledge(id, secret, key); err != nil {
+			return msg, true, err
+		}
+		return msg, true, nil
+	}
+	return msg, false, errors.New("Unknown receipt header")
+}
+
+// Start : Resumes tracking after Stop, rescheduling the timers of the receipts it already holds
+func (t *Tracker) Start() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if !t.stopped {
+		return
+	}
+	t.stopped = false
+	now := time.Now().UnixNano()
+	for id, r := range t.receipts {
+		id := id
+		if r.Status == api.ReceiptPending {
+			t.schedule(id, time.Duration(r.Expires-now), func() {
+				t.finish(id, api.ReceiptExpired)
+			})
+		} else {
+			t.schedule(id, Retention, func() {
+				t.forget(id)
+			})
+		}
+	}
+}
+
+// Stop : Cancels all pending timers, no more events are sent until Start
+func (t *Tracker) Stop() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.stopped = true
+	for id, timer := range t.timers {
+		timer.Stop()
+		delete(t.timers, id)
+	}
+}
+
+// acknowledge - sends a receipt for the given ID and secret back to the sender's content key
+func (t *Tracker) acknowledge(id, secret []byte, key string) error {
+	cid, err := t.node.CID()
+	if err != nil {
+		return err
+	}
+	destkey := cid.Clone()
+	if err := destkey.FromB64(key); err != nil {
+		return err
+	}
+	return t.node.SendMsg(api.Msg{Content: receiptHeader(id, secret), IsChan: false, PubKey: destkey, Receipt: true})
+}
+
+// finish - moves a pending receipt to its final status and reports it
+func (t *Tracker) finish(id string, status api.ReceiptStatus) {
+	t.mtx.Lock()
+	r, ok := t.receipts[id]
+	if !ok || r.Status != api.ReceiptPending || t.stopped {
+		t.mtx.Unlock()
+		return
+	}
+	r.Status = status
+	delete(t.secrets, id)
+	if status == api.ReceiptDelivered {
+		r.Delivered = time.Now().UnixNano()
+	}
+	receipt := *r
+	t.schedule(id, Retention, func() {
+		t.forget(id)
+	})
+	t.mtx.Unlock()
+
+	events.Emit(t.node, api.Info, api.Delivery, receipt)
+}
+
+// forget - drops a receipt once it is no longer retained
+func (t *Tracker) forget(id string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.receipts, id)
+	delete(t.secrets, id)
+	delete(t.timers, id)
+}
+
+// schedule - replaces the timer for a receipt, the caller must hold mtx
+func (t *Tracker) schedule(id string, d time.Duration, f func()) {
+	if timer, ok := t.timers[id]; ok {
+		timer.Stop()
+	}
+	t.timers[id] = time.AfterFunc(d, f)
+}
@@ -0,0 +1,64 @@
+package receipts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+)
+
+func Test_Tracker_Restart_1(t *testing.T) {
+	tracker := NewTracker(nil)
+	expires := func() int64 { return time.Now().Add(50 * time.Millisecond).UnixNano() }
+
+	tracker.Track(api.Msg{ReceiptID: []byte("before"), Expires: expires()})
+	tracker.Stop()
+	tracker.Start()
+	tracker.Track(api.Msg{ReceiptID: []byte("after"), Expires: expires()})
+
+	for _, id := range []string{"before", "after"} {
+		if r, _ := tracker.GetReceipt([]byte(id)); r == nil || r.Status != api.ReceiptPending {
+			t.Fatalf("receipt %s should be pending: %+v", id, r)
+		}
+	}
+	time.Sleep(150 * time.Millisecond)
+	for _, id := range []string{"before", "after"} {
+		if r, _ := tracker.GetReceipt([]byte(id)); r == nil || r.Status != api.ReceiptExpired {
+			t.Fatalf("receipt %s should have expired after a restart: %+v", id, r)
+		}
+	}
+}
+
+func Test_Plain_1(t *testing.T) {
+	msg := Plain(api.Msg{Content: bytes.NewBufferString("hello")})
+	if !msg.Receipt {
+		t.Fatal("plain direct message does not carry the receipt flag")
+	}
+	msg, deliver, err := NewTracker(nil).Handle(msg)
+	if err != nil || !deliver || msg.Content.String() != "hello" {
+		t.Fatalf("plain direct message was not delivered unchanged: %v %v %q", deliver, err, msg.Content.String())
+	}
+}
+
+func Test_Tracker_Forged_1(t *testing.T) {
+	tracker := NewTracker(nil)
+	id := []byte("id")
+	secret := bytes.Repeat([]byte{1}, secretSize)
+	tracker.Track(api.Msg{ReceiptID: id, Content: requestHeader(id, secret, "")})
+
+	// anyone can encrypt to the sender's content key, but only the recipient saw the secret
+	forged := bytes.Repeat([]byte{2}, secretSize)
+	if _, deliver, err := tracker.Handle(api.Msg{Content: receiptHeader(id, forged)}); err != nil || deliver {
+		t.Fatal("forged receipt was not ignored:", deliver, err)
+	}
+	if r, _ := tracker.GetReceipt(id); r == nil || r.Status != api.ReceiptPending {
+		t.Fatalf("forged receipt marked the message delivered: %+v", r)
+	}
+	if _, _, err := tracker.Handle(api.Msg{Content: receiptHeader(id, secret)}); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := tracker.GetReceipt(id); r == nil || r.Status != api.ReceiptDelivered {
+		t.Fatalf("receipt with the secret was not accepted: %+v", r)
+	}
+}
@@ -19,6 +19,7 @@ const (
 	APIID            = 1
 	APIDropoff       = 2
 	APIPickup        = 3
+	APIGetPeerList   = 4
 	APICID           = 16
 	APIGetContact    = 17
 	APIGetContacts   = 18
@@ -39,6 +40,13 @@ const (
 	APIDeletePeer    = 33
 	APISend          = 34
 	APISendChannel   = 35
+
+	APIGetPeerStats   = 37
+	APIResetPeerStats = 38
+
+	APIGetPolicyStatus = 39
+	APIAddPolicy       = 40
+	APIRemovePolicy    = 41
 )
 
 // API Parameter Data types
@@ -56,11 +64,16 @@ const (
 	APITypeProfileArray byte = 0x22
 	APITypePeerArray    byte = 0x23
 
+	APITypePeerStatsArray    byte = 0x24
+	APITypePolicyStatusArray byte = 0x25
+
 	APITypeContact byte = 0x30
 	APITypeChannel byte = 0x31
 	APITypeProfile byte = 0x32
 	APITypePeer    byte = 0x33
 
+	APITypePeerList byte = 0x34
+
 	APITypeBundle byte = 0x40
 )
 
@@ -91,6 +104,8 @@ func ActionToUint16(action string) uint16 {
 		return APIDropoff
 	case "Pickup":
 		return APIPickup
+	case "GetPeerList":
+		return APIGetPeerList
 	case "CID":
 		return APICID
 	case "GetContact":
@@ -131,6 +146,16 @@ func ActionToUint16(action string) uint16 {
 		return APISend
 	case "SendChannel":
 		return APISendChannel
+	case "GetPeerStats":
+		return APIGetPeerStats
+	case "ResetPeerStats":
+		return APIResetPeerStats
+	case "GetPolicyStatus":
+		return APIGetPolicyStatus
+	case "AddPolicy":
+		return APIAddPolicy
+	case "RemovePolicy":
+		return APIRemovePolicy
 	}
 	return APINull
 }
@@ -144,6 +169,8 @@ func ActionFromUint16(action uint16) string {
 		return "Dropoff"
 	case APIPickup:
 		return "Pickup"
+	case APIGetPeerList:
+		return "GetPeerList"
 	case APICID:
 		return "CID"
 	case APIGetContact:
@@ -184,6 +211,16 @@ func ActionFromUint16(action uint16) string {
 		return "Send"
 	case APISendChannel:
 		return "SendChannel"
+	case APIGetPeerStats:
+		return "GetPeerStats"
+	case APIResetPeerStats:
+		return "ResetPeerStats"
+	case APIGetPolicyStatus:
+		return "GetPolicyStatus"
+	case APIAddPolicy:
+		return "AddPolicy"
+	case APIRemovePolicy:
+		return "RemovePolicy"
 	}
 	return ""
 }
@@ -393,6 +430,31 @@ func serialize(w io.Writer, v interface{}) {
 			}
 		}
 		writeTLV(w, APITypePeerArray, b.Bytes())
+	case []PeerStats:
+		as := v.([]PeerStats)
+		b := bytes.NewBuffer([]byte{})
+		for _, s := range as {
+			writeLV(b, []byte(s.Host))
+			writeLV(b, []byte(s.LastError))
+			binary.Write(b, binary.BigEndian, []int64{s.LastPollLocal, s.LastPollRemote, s.LastSuccess, s.LastFailure,
+				s.Polls, s.Failures, s.TotalBytesTX, s.TotalBytesRX})
+		}
+		writeTLV(w, APITypePeerStatsArray, b.Bytes())
+	case []PolicyStatus:
+		as := v.([]PolicyStatus)
+		b := bytes.NewBuffer([]byte{})
+		for _, s := range as {
+			writeLV(b, []byte(s.Policy))
+			b.WriteByte(byte(s.State))
+			writeLV(b, []byte(s.Err))
+		}
+		writeTLV(w, APITypePolicyStatusArray, b.Bytes())
+	case *PeerList:
+		l := v.(*PeerList)
+		b := bytes.NewBuffer([]byte{})
+		writeLV(b, l.signedBytes())
+		writeLV(b, l.Signature)
+		writeTLV(w, APITypePeerList, b.Bytes())
 	case Bundle:
 		bundle := v.(Bundle)
 		b := bytes.NewBuffer([]byte{})
@@ -627,6 +689,89 @@ func deserialize(r io.Reader, t byte, v []byte) (interface{}, error) {
 		}
 		return peers, nil
 
+	case APITypePeerStatsArray:
+		var stats []PeerStats
+		b := bytes.NewBuffer(v)
+		for b.Len() > 0 {
+			var s PeerStats
+			va, err := readLV(b)
+			if err != nil {
+				return nil, err
+			}
+			s.Host = string(va)
+			va, err = readLV(b)
+			if err != nil {
+				return nil, err
+			}
+			s.LastError = string(va)
+			fields := []*int64{&s.LastPollLocal, &s.LastPollRemote, &s.LastSuccess, &s.LastFailure,
+				&s.Polls, &s.Failures, &s.TotalBytesTX, &s.TotalBytesRX}
+			for _, f := range fields {
+				if err := binary.Read(b, binary.BigEndian, f); err != nil {
+					return nil, err
+				}
+			}
+			stats = append(stats, s)
+		}
+		return stats, nil
+
+	case APITypePolicyStatusArray:
+		var status []PolicyStatus
+		b := bytes.NewBuffer(v)
+		for b.Len() > 0 {
+			var s PolicyStatus
+			va, err := readLV(b)
+			if err != nil {
+				return nil, err
+			}
+			s.Policy = string(va)
+			bt, err := b.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			s.State = PolicyState(bt)
+			va, err = readLV(b)
+			if err != nil {
+				return nil, err
+			}
+			s.Err = string(va)
+			status = append(status, s)
+		}
+		return status, nil
+
+	case APITypePeerList:
+		var l PeerList
+		b := bytes.NewBuffer(v)
+		signed, err := readLV(b)
+		if err != nil {
+			return nil, err
+		}
+		if l.Signature, err = readLV(b); err != nil {
+			return nil, err
+		}
+		b = bytes.NewBuffer(signed)
+		va, err := readLV(b)
+		if err != nil {
+			return nil, err
+		}
+		l.Origin = string(va)
+		if err := binary.Read(b, binary.BigEndian, &l.Time); err != nil {
+			return nil, err
+		}
+		for b.Len() > 0 {
+			var ad PeerAd
+			fields := []*string{&ad.Name, &ad.URI, &ad.Transport}
+			for _, f := range fields {
+				va, err := readLV(b)
+				if err != nil {
+					return nil, err
+				}
+				*f = string(va)
+			}
+			l.Peers = append(l.Peers, ad)
+		}
+		return &l, nil
+
 	case APITypeBundle:
 		var bundle Bundle
 		b := bytes.NewBuffer(v)
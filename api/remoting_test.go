@@ -1,6 +1,7 @@
 package api
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -84,3 +85,65 @@ func Test_ResponseRoundTrip_2(t *testing.T) {
 		t.Fatal("Before and After Errors do not match")
 	}
 }
+
+func Test_ResponseRoundTrip_PeerStats_1(t *testing.T) {
+	var resp RemoteResponse
+	resp.Value = []PeerStats{
+		{Host: "https://a:20001", LastPollLocal: 1, LastPollRemote: 2, LastSuccess: 3, Polls: 4, TotalBytesTX: 5, TotalBytesRX: 6},
+		{Host: "udp://b:20001", LastFailure: 7, LastError: "timeout", Failures: 8},
+	}
+	reresp, err := RemoteResponseFromBytes(RemoteResponseToBytes(&resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Value, reresp.Value) {
+		t.Fatalf("Before and After PeerStats do not match:\n%+v\n%+v", resp.Value, reresp.Value)
+	}
+	if ActionFromUint16(ActionToUint16("ResetPeerStats")) != "ResetPeerStats" {
+		t.Fatal("ResetPeerStats has no API ID")
+	}
+}
+
+func Test_ResponseRoundTrip_PeerList_1(t *testing.T) {
+	var resp RemoteResponse
+	resp.Value = &PeerList{
+		Origin: "b3JpZ2lu",
+		Time:   42,
+		Peers: []PeerAd{
+			{Name: "a", URI: "a:20001", Transport: "https"},
+			{Name: "b", URI: "b:20001"},
+		},
+		Signature: []byte{1, 2, 3},
+	}
+	reresp, err := RemoteResponseFromBytes(RemoteResponseToBytes(&resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Value, reresp.Value) {
+		t.Fatalf("Before and After PeerList do not match:\n%+v\n%+v", resp.Value, reresp.Value)
+	}
+	if ActionFromUint16(ActionToUint16("GetPeerList")) != "GetPeerList" {
+		t.Fatal("GetPeerList has no API ID")
+	}
+}
+
+func Test_ResponseRoundTrip_PolicyStatus_1(t *testing.T) {
+	var resp RemoteResponse
+	resp.Value = []PolicyStatus{
+		{Policy: "poll", State: StateRunning},
+		{Policy: "p2p", State: StateError, Err: "listen failed"},
+		{Policy: "server"},
+	}
+	reresp, err := RemoteResponseFromBytes(RemoteResponseToBytes(&resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Value, reresp.Value) {
+		t.Fatalf("Before and After PolicyStatus do not match:\n%+v\n%+v", resp.Value, reresp.Value)
+	}
+	for _, action := range []string{"GetPolicyStatus", "AddPolicy", "RemovePolicy"} {
+		if ActionFromUint16(ActionToUint16(action)) != action {
+			t.Fatal(action + " has no API ID")
+		}
+	}
+}
@@ -13,10 +13,52 @@ type Router interface {
 	MarshalJSON() (b []byte, e error)
 }
 
+// Filter : defines one stage of a router chain, run on each message before it is handled or forwarded
+type Filter interface {
+	// Filter : Inspect or transform the given message, returns false if the message should be dropped
+	Filter(node Node, msg Msg) (Msg, bool, error)
+
+	// MarshalJSON : Serialize this type to JSON
+	MarshalJSON() (b []byte, e error)
+}
+
+// ChannelRecrypter : a Node that can move an encrypted channel message to another of its channels,
+// for filters that copy messages between channels with different keys
+type ChannelRecrypter interface {
+	// RecryptChannel : Returns content encrypted to channel from, encrypted again to channel to
+	RecryptChannel(from, to string, content []byte) ([]byte, error)
+}
+
+// PersistentRouter : a Router whose loop detection state can be kept in a ReplayStore
+type PersistentRouter interface {
+	Router
+	// SetReplayStore : Reload previously seen nonces from the store and record new ones to it
+	SetReplayStore(store ReplayStore) error
+}
+
+// ReplayStore : storage for the nonces recorded by loop detection, so they survive a node restart
+type ReplayStore interface {
+	// AddSeenNonce : Record a nonce and the time it was first seen (UnixNano)
+	AddSeenNonce(nonce []byte, timestamp int64) error
+	// GetSeenNonces : Return the recorded nonces first seen after the given time (UnixNano)
+	GetSeenNonces(since int64) ([]SeenNonce, error)
+	// FlushSeenNonces : Delete the recorded nonces first seen before the given time (UnixNano)
+	FlushSeenNonces(before int64) error
+}
+
+// SeenNonce : a message nonce recorded by loop detection
+type SeenNonce struct {
+	Nonce     []byte `db:"nonce"`
+	Timestamp int64  `db:"timestamp"`
+}
+
 // Patch : defines a mapping from an incoming channel to one or more destination channels.
+// From is an exact channel name, a prefix ending in "*" (e.g. "ops.*"), or a glob pattern as in path.Match.
+// Channels matching any of the Exclude patterns are not redirected by this patch.
 type Patch struct {
-	From string
-	To   []string
+	From    string
+	To      []string
+	Exclude []string
 }
 
 const (
@@ -26,4 +68,9 @@ const (
 	ChunkedFlag = 0x02
 	// ChannelFlag : this message has a channel name prefix
 	ChannelFlag = 0x04
+	// HopLimitFlag : this message has a hop limit byte following the flags byte
+	HopLimitFlag = 0x08
+	// ReceiptFlag : the encrypted content of this message begins with a delivery receipt header,
+	// which every direct message carries so the flag does not show which ones ask for a receipt
+	ReceiptFlag = 0x10
 )
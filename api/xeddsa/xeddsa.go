@@ -0,0 +1,141 @@
+// Package xeddsa : XEdDSA signatures made with X25519 (Curve25519) key pairs, as specified by Signal,
+// so the ecc routing keys of nodes can sign.
+// The Montgomery public key is mapped to the Edwards point with a positive x coordinate,
+// and the signature is an ordinary Ed25519 signature by that point, so crypto/ed25519 verifies it.
+// The point arithmetic comes from crypto/ecdh, which only returns u coordinates,
+// so the signer settles the signs of the key and nonce by checking which of the candidates verifies.
+// The scalar arithmetic uses math/big, which does not run in constant time.
+package xeddsa
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+)
+
+var (
+	// p - the field prime, 2^255 - 19
+	p = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	// q - the order of the base point, 2^252 + 27742317777372353535851937790883648493
+	q, _ = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+)
+
+// Sign : Returns the signature of message by the X25519 private key priv, whose public key is pub
+func Sign(priv, pub, message []byte) ([]byte, error) {
+	if len(priv) != 32 || len(pub) != 32 {
+		return nil, errors.New("xeddsa: keys must be 32 bytes")
+	}
+	k := clamp(priv)
+	key, err := ecdh.X25519().NewPrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(key.PublicKey().Bytes(), pub) {
+		return nil, errors.New("xeddsa: public key does not match the private key")
+	}
+	A, err := edwards(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	// nonce from the key, the message, and randomness, as in XEdDSA
+	z := make([]byte, 64)
+	if _, err := rand.Read(z); err != nil {
+		return nil, err
+	}
+	h := sha512.New()
+	h.Write(append([]byte{0xFE}, bytes.Repeat([]byte{0xFF}, 31)...))
+	h.Write(k)
+	h.Write(message)
+	h.Write(z)
+	rb := clamp(h.Sum(nil)[:32])
+	nonce, err := ecdh.X25519().NewPrivateKey(rb)
+	if err != nil {
+		return nil, err
+	}
+	R, err := edwards(nonce.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	h = sha512.New()
+	h.Write(R)
+	h.Write(A)
+	h.Write(message)
+	c := new(big.Int).Mod(fromLE(h.Sum(nil)), q)
+	a := new(big.Int).Mod(fromLE(k), q)
+	r := new(big.Int).Mod(fromLE(rb), q)
+
+	// A and R stand for a or -a times the base point, and r or -r, whichever has a positive x
+	for _, rs := range []*big.Int{r, new(big.Int).Sub(q, r)} {
+		for _, as := range []*big.Int{a, new(big.Int).Sub(q, a)} {
+			s := new(big.Int).Mul(c, as)
+			s.Add(s, rs).Mod(s, q)
+			sig := append(append([]byte{}, R...), toLE(s)...)
+			if ed25519.Verify(A, message, sig) {
+				return sig, nil
+			}
+		}
+	}
+	return nil, errors.New("xeddsa: could not sign")
+}
+
+// Verify : Returns whether sig is a valid signature of message by the X25519 public key pub
+func Verify(pub, message, sig []byte) bool {
+	if len(pub) != 32 || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	A, err := edwards(pub)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(A, message, sig)
+}
+
+// edwards - converts a Montgomery u coordinate to the encoding of the Edwards point with a positive x
+func edwards(u []byte) (ed25519.PublicKey, error) {
+	x := fromLE(u)
+	if x.Cmp(p) >= 0 {
+		return nil, errors.New("xeddsa: public key out of range")
+	}
+	// y = (u - 1) / (u + 1)
+	d := new(big.Int).Add(x, big.NewInt(1))
+	if d.Mod(d, p).Sign() == 0 {
+		return nil, errors.New("xeddsa: public key has no Edwards form")
+	}
+	y := new(big.Int).Sub(x, big.NewInt(1))
+	y.Mul(y, d.ModInverse(d, p)).Mod(y, p)
+	return toLE(y), nil // y < p leaves the sign bit clear
+}
+
+// clamp - returns a copy of an X25519 scalar with the bits set and cleared as X25519 does
+func clamp(b []byte) []byte {
+	k := append([]byte{}, b...)
+	k[0] &= 248
+	k[31] &= 127
+	k[31] |= 64
+	return k
+}
+
+// fromLE - reads a little-endian integer
+func fromLE(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// toLE - writes an integer below 2^256 as 32 little-endian bytes
+func toLE(n *big.Int) []byte {
+	be := n.FillBytes(make([]byte, 32))
+	le := make([]byte, 32)
+	for i := range be {
+		le[31-i] = be[i]
+	}
+	return le
+}
@@ -0,0 +1,35 @@
+package xeddsa
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+func Test_SignVerify_1(t *testing.T) {
+	message := []byte("peer list")
+	for i := 0; i < 20; i++ { // keys and nonces of either sign
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub := key.PublicKey().Bytes()
+		sig, err := Sign(key.Bytes(), pub, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Verify(pub, message, sig) {
+			t.Fatal("signature did not verify")
+		}
+		if Verify(pub, []byte("peer lisT"), sig) {
+			t.Fatal("signature verified for another message")
+		}
+		other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+		if Verify(other.PublicKey().Bytes(), message, sig) {
+			t.Fatal("signature verified for another key")
+		}
+		if _, err := Sign(key.Bytes(), other.PublicKey().Bytes(), message); err == nil {
+			t.Fatal("signed with a public key of another private key")
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package keytest : a routing key type that only signs, for tests of the code that signs and verifies
+// with routing keys that do not depend on a particular key type
+package keytest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+
+	"github.com/awgh/bencrypt/bc"
+)
+
+// KeyPair : an ed25519 key pair, it signs but can not encrypt
+type KeyPair struct {
+	priv ed25519.PrivateKey
+}
+
+// PubKey : the public half of a KeyPair, it verifies the KeyPair's signatures
+type PubKey struct {
+	pub ed25519.PublicKey
+}
+
+// New : Returns a freshly generated KeyPair
+func New() *KeyPair {
+	k := new(KeyPair)
+	k.GenerateKey()
+	return k
+}
+
+// GetName : Returns the name of this key type
+func (k *KeyPair) GetName() string {
+	return "ed25519-test"
+}
+
+// GetPubKey : Returns the public half of the key
+func (k *KeyPair) GetPubKey() bc.PubKey {
+	return &PubKey{pub: k.priv.Public().(ed25519.PublicKey)}
+}
+
+// GenerateKey : Replaces the key with a random one
+func (k *KeyPair) GenerateKey() {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	k.priv = priv
+}
+
+// ToB64 : Returns the private key in base64
+func (k *KeyPair) ToB64() string {
+	return base64.StdEncoding.EncodeToString(k.priv)
+}
+
+// FromB64 : Sets the private key from base64
+func (k *KeyPair) FromB64(s string) error {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(b) != ed25519.PrivateKeySize {
+		return errors.New("Invalid ed25519 private key")
+	}
+	k.priv = b
+	return nil
+}
+
+// ValidatePubKey : Returns whether s is a base64 public key of this type
+func (k *KeyPair) ValidatePubKey(s string) bool {
+	return new(PubKey).FromB64(s) == nil
+}
+
+// EncryptMessage : Fails, this key type does not encrypt
+func (k *KeyPair) EncryptMessage(clear []byte, pubkey bc.PubKey) ([]byte, error) {
+	return nil, errors.New("ed25519-test keys do not encrypt")
+}
+
+// DecryptMessage : Fails, this key type does not encrypt
+func (k *KeyPair) DecryptMessage(data []byte) (bool, []byte, error) {
+	return false, nil, errors.New("ed25519-test keys do not decrypt")
+}
+
+// Clone : Returns a copy of the key
+func (k *KeyPair) Clone() bc.KeyPair {
+	return &KeyPair{priv: append(ed25519.PrivateKey{}, k.priv...)}
+}
+
+// Sign : Returns the ed25519 signature of data
+func (k *KeyPair) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(k.priv, data), nil
+}
+
+// ToB64 : Returns the public key in base64
+func (p *PubKey) ToB64() string {
+	return base64.StdEncoding.EncodeToString(p.pub)
+}
+
+// FromB64 : Sets the public key from base64
+func (p *PubKey) FromB64(s string) error {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return p.FromBytes(b)
+}
+
+// ToBytes : Returns the public key
+func (p *PubKey) ToBytes() []byte {
+	return p.pub
+}
+
+// FromBytes : Sets the public key
+func (p *PubKey) FromBytes(b []byte) error {
+	if len(b) != ed25519.PublicKeySize {
+		return errors.New("Invalid ed25519 public key")
+	}
+	p.pub = append(ed25519.PublicKey{}, b...)
+	return nil
+}
+
+// Nil : Returns the nil public key of this type
+func (p *PubKey) Nil() bc.PubKey {
+	return nil
+}
+
+// Clone : Returns a copy of the public key
+func (p *PubKey) Clone() bc.PubKey {
+	return &PubKey{pub: append(ed25519.PublicKey{}, p.pub...)}
+}
+
+// Verify : Returns whether signature is a valid ed25519 signature of data by this key
+func (p *PubKey) Verify(data []byte, signature []byte) bool {
+	return len(p.pub) == ed25519.PublicKeySize && ed25519.Verify(p.pub, data, signature)
+}
@@ -2,7 +2,6 @@ package db
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"time"
 
@@ -323,7 +322,53 @@ func (node *Node) dbDeletePeer(name string) {
 	_ = res.Delete()
 }
 
-func (node *Node) dbOutboxEnqueue(channelName string, msg []byte, ts int64, checkExists bool) error {
+func (node *Node) dbGetPeerStats(hosts []string) ([]api.PeerStats, error) {
+	col := node.db.Collection("peerstats")
+	var stats []api.PeerStats
+	if len(hosts) == 0 {
+		if err := col.Find().OrderBy("host").All(&stats); err != nil {
+			return nil, err
+		}
+		return stats, nil
+	}
+	for _, host := range hosts {
+		var found []api.PeerStats
+		if err := col.Find("host = ?", host).All(&found); err != nil {
+			return nil, err
+		}
+		stats = append(stats, found...)
+	}
+	return stats, nil
+}
+
+func (node *Node) dbSetPeerStats(stats api.PeerStats) error {
+	col := node.db.Collection("peerstats")
+	res := col.Find("host = ?", stats.Host)
+	count, err := res.Count()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err = col.Insert(stats)
+		return err
+	}
+	return res.Update(stats)
+}
+
+func (node *Node) dbResetPeerStats(hosts []string) error {
+	col := node.db.Collection("peerstats")
+	if len(hosts) == 0 {
+		return col.Find().Delete()
+	}
+	for _, host := range hosts {
+		if err := col.Find("host = ?", host).Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (node *Node) dbOutboxEnqueue(channelName string, msg []byte, ts, expires int64, priority uint8, checkExists bool) error {
 	col := node.db.Collection("outbox")
 	doInsert := !checkExists
 	var outboxmsg api.OutboxMsg
@@ -347,6 +392,8 @@ func (node *Node) dbOutboxEnqueue(channelName string, msg []byte, ts int64, chec
 		outboxmsg.Channel = channelName
 		outboxmsg.Msg = msg
 		outboxmsg.Timestamp = ts
+		outboxmsg.Expires = expires
+		outboxmsg.Priority = priority
 		_, err := col.Insert(&outboxmsg)
 		return err
 	}
@@ -379,24 +426,21 @@ func (node *Node) dbGetMessages(lastTime, maxBytes int64, channelNames ...string
 	lastTimeReturned := lastTime
 	var args []interface{}
 	var msgs [][]byte
-	var bytesRead int64
+	var entries []api.PickupEntry
 
 	// Build the query
 	wildcard := false
 	if len(channelNames) < 1 {
 		wildcard = true // if no channels are given, get everything
 	}
-	sqlq := "SELECT msg, timestamp FROM outbox"
+	sqlq := "SELECT msg, timestamp, priority FROM outbox WHERE (expires = 0 OR ? < expires)"
+	args = append(args, time.Now().UnixNano())
 	if lastTime != 0 {
-		sqlq += " WHERE (? < timestamp)"
+		sqlq += " AND (? < timestamp)"
 		args = append(args, lastTime)
 	}
 	if !wildcard && len(channelNames) > 0 {
-		if lastTime != 0 {
-			sqlq += " AND"
-		} else {
-			sqlq += " WHERE"
-		}
+		sqlq += " AND"
 		sqlq = sqlq + " channel IN( ?"
 		args = append(args, channelNames[0])
 		for i := 1; i < len(channelNames); i++ {
@@ -411,27 +455,26 @@ func (node *Node) dbGetMessages(lastTime, maxBytes int64, channelNames ...string
 	if res == nil || err != nil {
 		return nil, lastTimeReturned, err
 	}
-	n := 0
+	defer res.Close()
 	for res.Next() {
-		n++
 		var msg []byte
-		var ts int64
-		res.Scan(&msg, &ts)
-		if bytesRead+int64(len(msg)) >= maxBytes { // no room for next msg
-			events.Debug(node, "skipping messages after %d results\n", n)
-			if n == 0 {
-				return nil, lastTimeReturned, errors.New("Result too big to be fetched on this transport! Flush and rechunk")
-			}
-		}
-		if ts > lastTimeReturned {
-			lastTimeReturned = ts
-		} else {
-			events.Error(node, "Timestamps not increasing - prev: %d  cur: %d\n", lastTimeReturned, ts)
-		}
+		var ts, priority int64
+		res.Scan(&msg, &ts, &priority)
 		msgs = append(msgs, msg)
-		bytesRead += int64(len(msg))
+		entries = append(entries, api.PickupEntry{Size: int64(len(msg)), Timestamp: ts, Priority: uint8(priority)})
 	}
-	return msgs, lastTimeReturned, nil
+
+	// fill the bundle by priority, then age
+	chosen, lastTimeReturned, tooBig := api.PackPickup(entries, lastTime, maxBytes)
+	if tooBig {
+		events.Warning(node, "Result too big to be fetched on this transport! Flush and rechunk")
+	}
+	picked := make([][]byte, len(chosen))
+	for i, c := range chosen {
+		picked[i] = msgs[c]
+	}
+	events.Debug(node, "picked up messages:", len(picked), "of", len(msgs))
+	return picked, lastTimeReturned, nil
 }
 
 func (node *Node) dbClearStream(streamID uint32) error {
@@ -525,13 +568,65 @@ func (node *Node) dbGetChunks(streamID uint32) ([]api.Chunk, error) {
 	return chunks, nil
 }
 
-// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds
+// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds, or past their own deadline
 func (node *Node) FlushOutbox(maxAgeSeconds int64) {
-	ts := time.Now().UnixNano()
-	ts = ts - (maxAgeSeconds * 1000000000)
+	now := time.Now().UnixNano()
+	ts := now - (maxAgeSeconds * 1000000000)
 	col := node.db.Collection("outbox")
 	res := col.Find("timestamp < ?", ts)
 	_ = res.Delete()
+	res = col.Find("expires > ? AND expires < ?", 0, now)
+	_ = res.Delete()
+}
+
+// QueueStats : Returns the current outbox and stream reassembly backlog
+func (node *Node) QueueStats() (api.QueueStats, error) {
+	var stats api.QueueStats
+	res, err := node.db.Query("SELECT COUNT(*), COALESCE(SUM(LENGTH(msg)), 0) FROM outbox;")
+	if res == nil || err != nil {
+		return stats, err
+	}
+	defer res.Close()
+	if res.Next() {
+		if err := res.Scan(&stats.OutboxMsgs, &stats.OutboxBytes); err != nil {
+			return stats, err
+		}
+	}
+	streams, err := node.db.Collection("streams").Find().Count()
+	if err != nil {
+		return stats, err
+	}
+	chunks, err := node.db.Collection("chunks").Find().Count()
+	if err != nil {
+		return stats, err
+	}
+	stats.Streams, stats.Chunks = int(streams), int(chunks)
+	return stats, nil
+}
+
+// AddSeenNonce - implemented from ReplayStore API
+func (node *Node) AddSeenNonce(nonce []byte, timestamp int64) error {
+	col := node.db.Collection("seen")
+	_, err := col.Insert(api.SeenNonce{Nonce: nonce, Timestamp: timestamp})
+	return err
+}
+
+// GetSeenNonces - implemented from ReplayStore API
+func (node *Node) GetSeenNonces(since int64) ([]api.SeenNonce, error) {
+	col := node.db.Collection("seen")
+	res := col.Find("timestamp > ?", since)
+	var seen []api.SeenNonce
+	if err := res.All(&seen); err != nil {
+		return nil, err
+	}
+	return seen, nil
+}
+
+// FlushSeenNonces - implemented from ReplayStore API
+func (node *Node) FlushSeenNonces(before int64) error {
+	col := node.db.Collection("seen")
+	res := col.Find("timestamp < ?", before)
+	return res.Delete()
 }
 
 type connectionURL struct {
@@ -590,10 +685,15 @@ func (node *Node) BootstrapDB(dbAdapter, dbConnectionString string) sqlbuilder.D
 		CREATE TABLE IF NOT EXISTS outbox (
 			channel		%s, 
 			msg			%s	NOT NULL,
-			timestamp	%s	NOT NULL
+			timestamp	%s	NOT NULL,
+			expires		%s	NOT NULL DEFAULT 0,
+			priority	%s	NOT NULL DEFAULT 0
 		);
-	`, strName, blobName, int64Name))
+	`, strName, blobName, int64Name, int64Name, int64Name))
 	checkErr(err)
+	// databases created before message deadlines and priorities need the columns added, this fails harmlessly if they exist
+	_, _ = node.db.Exec(fmt.Sprintf(`ALTER TABLE outbox ADD COLUMN expires %s NOT NULL DEFAULT 0;`, int64Name))
+	_, _ = node.db.Exec(fmt.Sprintf(`ALTER TABLE outbox ADD COLUMN priority %s NOT NULL DEFAULT 0;`, int64Name))
 
 	_, err = node.db.Exec(`
 			CREATE INDEX IF NOT EXISTS outboxID ON outbox (timestamp);
@@ -611,6 +711,22 @@ func (node *Node) BootstrapDB(dbAdapter, dbConnectionString string) sqlbuilder.D
 	`, strName, strName, strName, strName))
 	checkErr(err)
 
+	_, err = node.db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS peerstats (
+			host			%s	NOT NULL,
+			lastpolllocal	%s	NOT NULL,
+			lastpollremote	%s	NOT NULL,
+			lastsuccess		%s	NOT NULL,
+			lastfailure		%s	NOT NULL,
+			lasterror		%s	NOT NULL,
+			polls			%s	NOT NULL,
+			failures		%s	NOT NULL,
+			bytestx			%s	NOT NULL,
+			bytesrx			%s	NOT NULL
+		);
+	`, strName, int64Name, int64Name, int64Name, int64Name, strName, int64Name, int64Name, int64Name, int64Name))
+	checkErr(err)
+
 	_, err = node.db.Exec(fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS profiles (
 			name	%s		NOT NULL,
@@ -638,6 +754,19 @@ func (node *Node) BootstrapDB(dbAdapter, dbConnectionString string) sqlbuilder.D
 	`, int64Name, int64Name, strName))
 	checkErr(err)
 
+	_, err = node.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS seen (
+		nonce		%s	NOT NULL,
+		timestamp	%s	NOT NULL
+	);
+	`, blobName, int64Name))
+	checkErr(err)
+
+	_, err = node.db.Exec(`
+			CREATE INDEX IF NOT EXISTS seenID ON seen (timestamp);
+	`)
+	checkErr(err)
+
 	// Content Key Setup
 	col := node.db.Collection("config")
 	res1 := col.Find("name = ?", "contentkey")
@@ -9,6 +9,7 @@ import (
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/receipts"
 	"github.com/awgh/ratnet/nodes"
 	"github.com/awgh/ratnet/router"
 	"upper.io/db.v3/lib/sqlbuilder"
@@ -22,8 +23,9 @@ type Node struct {
 	routingKey  bc.KeyPair
 	channelKeys map[string]bc.KeyPair
 
-	policies []api.Policy
-	router   api.Router
+	policies  []api.Policy
+	policyMtx sync.Mutex
+	router    api.Router
 
 	db sqlbuilder.Database
 
@@ -37,6 +39,8 @@ type Node struct {
 	in     chan api.Msg
 	out    chan api.Msg
 	events chan api.Event
+
+	receipts *receipts.Tracker
 }
 
 // New : creates a new instance of API
@@ -60,17 +64,49 @@ func New(contentKey, routingKey bc.KeyPair) *Node {
 	// setup default router
 	node.router = router.NewDefaultRouter()
 
+	// track delivery receipts
+	node.receipts = receipts.NewTracker(node)
+
 	return node
 }
 
 // GetPolicies : returns the array of Policy objects for this Node
 func (node *Node) GetPolicies() []api.Policy {
-	return node.policies
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	return append([]api.Policy(nil), node.policies...)
 }
 
-// SetPolicy : set the array of Policy objects for this Node
+// SetPolicy : set the array of Policy objects for this Node, stopping the old ones and starting the new ones if it is running
 func (node *Node) SetPolicy(policies ...api.Policy) {
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	if node.isRunning {
+		nodes.SwapPolicies(node, node.policies, policies)
+	}
+	node.policies = policies
+}
+
+// AddPolicy : Adds a Policy to this Node, starting it if the Node is running
+func (node *Node) AddPolicy(policy api.Policy) error {
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	if node.isRunning {
+		if err := policy.RunPolicy(); err != nil {
+			return err
+		}
+	}
+	node.policies = append(node.policies, policy)
+	return nil
+}
+
+// RemovePolicy : Stops a Policy of this Node and removes it
+func (node *Node) RemovePolicy(policy api.Policy) error {
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	policies, err := nodes.RemovePolicy(node.policies, policy)
 	node.policies = policies
+	return err
 }
 
 // Router : get the Router object for this Node
@@ -83,6 +119,21 @@ func (node *Node) SetRouter(router api.Router) {
 	node.router = router
 }
 
+// GetReceipt : Returns the delivery status of a message sent with the given ReceiptID, nil if unknown
+func (node *Node) GetReceipt(id []byte) (*api.Receipt, error) {
+	return node.receipts.GetReceipt(id)
+}
+
+// SignRouting : Signs data with the routing key, ErrCannotSign if its key type cannot sign
+func (node *Node) SignRouting(data []byte) ([]byte, error) {
+	return api.Sign(node.routingKey, data)
+}
+
+// SetPeerStats : Replaces the poll statistics kept for stats.Host
+func (node *Node) SetPeerStats(stats api.PeerStats) error {
+	return node.dbSetPeerStats(stats)
+}
+
 // Channels
 
 // In : Returns the In channel of this node
@@ -5,9 +5,11 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/awgh/bencrypt/ecc"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/router"
 
 	_ "upper.io/db.v3/ql"
 )
@@ -82,6 +84,54 @@ func Test_apicall_Channels_1(t *testing.T) {
 	t.Log(message)
 }
 
+func Test_Replay_Persist_1(t *testing.T) {
+	if err := node.FlushSeenNonces(time.Now().UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	nonce := bytes.Repeat([]byte{5}, 32)
+	r := router.NewDefaultRouter()
+	r.PersistReplay = true
+	if err := r.SetReplayStore(node); err != nil {
+		t.Fatal(err)
+	}
+	if r.SeenRecently(nonce) {
+		t.Fatal("new nonce reported as seen")
+	}
+	if err := r.Persist(nonce); err != nil {
+		t.Fatal(err)
+	}
+	// a new router on the same database, as after a restart
+	restarted := router.NewDefaultRouter()
+	restarted.PersistReplay = true
+	if err := restarted.SetReplayStore(node); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.SeenRecently(nonce) {
+		t.Fatal("replayed nonce accepted after a restart")
+	}
+}
+
+func Test_apicall_Pickup_TooBig_1(t *testing.T) {
+	node.FlushOutbox(0)
+	big := api.Msg{Name: "big", IsChan: true, Content: bytes.NewBuffer(bytes.Repeat([]byte{1}, 1024))}
+	if err := node.Forward(big); err != nil {
+		t.Fatal(err)
+	}
+	rpk, err := node.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the only message can never fit, the cursor has to move past it or the peer is stuck
+	bundle, err := node.Pickup(rpk, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Data) != 0 || bundle.Time == 0 {
+		t.Fatalf("oversized message was not skipped: %d bytes, cursor %d", len(bundle.Data), bundle.Time)
+	}
+	node.FlushOutbox(0)
+}
+
 func Test_stop(t *testing.T) {
 	node.Stop()
 }
@@ -111,8 +111,12 @@ func (node *Node) Import(jsonConfig []byte) error {
 		node.dbAddProfilePriv(cp.Name, cp.Enabled, cp.Privkey.ToB64())
 	}
 
-	if len(nj.Router) < 0 {
-		node.SetRouter(ratnet.NewRouterFromMap(nj.Router))
+	if len(nj.Router) > 0 {
+		router, err := ratnet.NewRouterFromMap(nj.Router)
+		if err != nil {
+			return err
+		}
+		node.SetRouter(router)
 	}
 
 	for _, p := range nj.Policies {
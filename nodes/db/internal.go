@@ -18,6 +18,22 @@ func (node *Node) GetChannelPrivKey(name string) (string, error) {
 	return node.dbGetChannelPrivKey(name)
 }
 
+// RecryptChannel : Returns content encrypted to channel from, encrypted again to channel to
+func (node *Node) RecryptChannel(from, to string, content []byte) ([]byte, error) {
+	src, ok := node.channelKeys[from]
+	dst, ok2 := node.channelKeys[to]
+	if !ok || !ok2 {
+		return nil, errors.New("Channel not found")
+	}
+	tagOK, clear, err := src.DecryptMessage(content)
+	if err != nil {
+		return nil, err
+	} else if !tagOK {
+		return nil, errors.New("Message is not for channel " + from)
+	}
+	return node.contentKey.EncryptMessage(clear, dst.GetPubKey())
+}
+
 // Forward - Add an already-encrypted message to the outbound message queue (forward it along)
 func (node *Node) Forward(msg api.Msg) error {
 
@@ -31,7 +47,16 @@ func (node *Node) Forward(msg api.Msg) error {
 	if msg.StreamHeader {
 		flags |= api.StreamHeaderFlag
 	}
+	if msg.HopLimit > 0 {
+		flags |= api.HopLimitFlag
+	}
+	if msg.Receipt {
+		flags |= api.ReceiptFlag
+	}
 	rxsum := []byte{flags} // prepend flags byte
+	if msg.HopLimit > 0 {
+		rxsum = append(rxsum, msg.HopLimit)
+	}
 	if msg.IsChan {
 		// prepend a uint16 of channel name length, little-endian
 		t := uint16(len(msg.Name))
@@ -39,7 +64,11 @@ func (node *Node) Forward(msg api.Msg) error {
 		rxsum = append(rxsum, []byte(msg.Name)...)
 	}
 	message := append(rxsum, msg.Content.Bytes()...)
-	return node.dbOutboxEnqueue(msg.Name, message, time.Now().UnixNano(), false)
+	if err := node.dbOutboxEnqueue(msg.Name, message, time.Now().UnixNano(), 0, 0, false); err != nil {
+		return err
+	}
+	events.Emit(node, api.Debug, api.MessageForwarded, api.MessageEvent{Channel: msg.Name, IsChan: msg.IsChan, Size: len(message)})
+	return nil
 }
 
 // Handle - Decrypt and handle an encrypted message
@@ -66,6 +95,16 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 	}
 	clearMsg.Content = bytes.NewBuffer(clear)
 
+	// direct messages may carry a delivery receipt request, or be a receipt
+	if msg.Receipt && !msg.IsChan {
+		var deliver bool
+		if clearMsg, deliver, err = node.receipts.Handle(clearMsg); !deliver {
+			return true, err
+		} else if err != nil {
+			events.Warning(node, "could not send delivery receipt: "+err.Error())
+		}
+	}
+
 	if msg.Chunked {
 		err = chunking.HandleChunked(node, clearMsg)
 		if err != nil {
@@ -77,6 +116,7 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 	select {
 	case node.Out() <- clearMsg:
 		events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+		events.Emit(node, api.Info, api.MessageReceived, api.MessageEvent{Channel: msg.Name, IsChan: msg.IsChan, Size: clearMsg.Content.Len()})
 	default:
 		events.Debug(node, "No message sent")
 	}
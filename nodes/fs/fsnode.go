@@ -4,11 +4,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/receipts"
 	"github.com/awgh/ratnet/nodes"
 	"github.com/awgh/ratnet/router"
 )
@@ -27,6 +31,7 @@ type Node struct {
 	routingKey bc.KeyPair
 
 	policies  []api.Policy
+	policyMtx sync.Mutex
 	router    api.Router
 	isRunning bool
 	debugMode bool
@@ -36,6 +41,8 @@ type Node struct {
 	out    chan api.Msg
 	events chan api.Event
 
+	receipts *receipts.Tracker
+
 	// db -> ram replacements
 	channels map[string]*api.ChannelPriv
 	config   map[string]string
@@ -44,12 +51,27 @@ type Node struct {
 	profiles map[string]*api.ProfilePriv
 	streams  map[uint32]*api.StreamHeader
 	chunks   map[uint32]map[uint32]*api.Chunk
+	// streamMtx - guards streams and chunks, they are filled by Handle and read by reassembly and QueueStats
+	streamMtx sync.Mutex
 
 	//outbox   []*outboxMsg
 	basePath    string
 	outboxIndex uint32
+
+	replayMtx sync.Mutex
+	replayOut *os.File // kept open for appending, closed by Stop and before the file is rewritten
+
+	peerStats    map[string]*api.PeerStats
+	peerStatsMtx sync.Mutex
 }
 
+// replayFile - name of the file under basePath holding loop detection state,
+// hidden files are never treated as outbox messages
+const replayFile = ".replay"
+
+// peerStatsFile - name of the file under basePath holding the peer stats, so poll cursors survive a restart
+const peerStatsFile = ".peerstats"
+
 // New : creates a new instance of API
 func New(contentKey, routingKey bc.KeyPair, basePath string) *Node {
 	// create node
@@ -60,6 +82,7 @@ func New(contentKey, routingKey bc.KeyPair, basePath string) *Node {
 	node.config = make(map[string]string)
 	node.contacts = make(map[string]*api.Contact)
 	node.peers = make(map[string]*api.Peer)
+	node.peerStats = make(map[string]*api.PeerStats)
 	node.profiles = make(map[string]*api.ProfilePriv)
 	node.streams = make(map[uint32]*api.StreamHeader)
 	node.chunks = make(map[uint32]map[uint32]*api.Chunk)
@@ -76,8 +99,14 @@ func New(contentKey, routingKey bc.KeyPair, basePath string) *Node {
 	// setup default router
 	node.router = router.NewDefaultRouter()
 
+	// track delivery receipts
+	node.receipts = receipts.NewTracker(node)
+
 	node.basePath = basePath
 	os.Mkdir(basePath, 0700)
+	if err := node.loadPeerStats(); err != nil {
+		events.Warning(node, "could not load peer stats: "+err.Error())
+	}
 
 	return node
 }
@@ -86,14 +115,74 @@ func hex(n uint32) string {
 	return fmt.Sprintf("%08x", n)
 }
 
+// outboxFileName - messages with a deadline or a priority carry them in the file name, after the index
+func outboxFileName(index uint32, expires int64, priority uint8) string {
+	name := hex(index)
+	if expires != 0 || priority != 0 {
+		name += "-" + strconv.FormatInt(expires, 16)
+	}
+	if priority != 0 {
+		name += "-" + strconv.FormatUint(uint64(priority), 16)
+	}
+	return name
+}
+
+// parseOutboxFileName - returns the deadline and priority stored in an outbox file name
+func parseOutboxFileName(name string) (expires int64, priority uint8) {
+	fields := strings.Split(name, "-")
+	if len(fields) > 1 {
+		expires, _ = strconv.ParseInt(fields[1], 16, 64)
+	}
+	if len(fields) > 2 {
+		p, _ := strconv.ParseUint(fields[2], 16, 8)
+		priority = uint8(p)
+	}
+	return expires, priority
+}
+
+// fileExpired - returns whether an outbox file has passed the deadline in its name
+func fileExpired(name string, now int64) bool {
+	expires, _ := parseOutboxFileName(name)
+	return expires != 0 && expires < now
+}
+
 // GetPolicies : returns the array of Policy objects for this Node
 func (node *Node) GetPolicies() []api.Policy {
-	return node.policies
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	return append([]api.Policy(nil), node.policies...)
 }
 
-// SetPolicy : set the array of Policy objects for this Node
+// SetPolicy : set the array of Policy objects for this Node, stopping the old ones and starting the new ones if it is running
 func (node *Node) SetPolicy(policies ...api.Policy) {
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	if node.isRunning {
+		nodes.SwapPolicies(node, node.policies, policies)
+	}
+	node.policies = policies
+}
+
+// AddPolicy : Adds a Policy to this Node, starting it if the Node is running
+func (node *Node) AddPolicy(policy api.Policy) error {
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	if node.isRunning {
+		if err := policy.RunPolicy(); err != nil {
+			return err
+		}
+	}
+	node.policies = append(node.policies, policy)
+	return nil
+}
+
+// RemovePolicy : Stops a Policy of this Node and removes it
+func (node *Node) RemovePolicy(policy api.Policy) error {
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	policies, err := nodes.RemovePolicy(node.policies, policy)
 	node.policies = policies
+	return err
 }
 
 // Router : get the Router object for this Node
@@ -106,7 +195,25 @@ func (node *Node) SetRouter(router api.Router) {
 	node.router = router
 }
 
-// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds
+// GetReceipt : Returns the delivery status of a message sent with the given ReceiptID, nil if unknown
+func (node *Node) GetReceipt(id []byte) (*api.Receipt, error) {
+	return node.receipts.GetReceipt(id)
+}
+
+// SignRouting : Signs data with the routing key, ErrCannotSign if its key type cannot sign
+func (node *Node) SignRouting(data []byte) ([]byte, error) {
+	return api.Sign(node.routingKey, data)
+}
+
+// SetPeerStats : Replaces the poll statistics kept for stats.Host
+func (node *Node) SetPeerStats(stats api.PeerStats) error {
+	node.peerStatsMtx.Lock()
+	defer node.peerStatsMtx.Unlock()
+	node.peerStats[stats.Host] = &stats
+	return node.savePeerStats()
+}
+
+// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds, or past their own deadline
 func (node *Node) FlushOutbox(maxAgeSeconds int64) {
 	now := time.Now()
 	_ = filepath.Walk(node.basePath, func(path string, info os.FileInfo, err error) error {
@@ -114,8 +221,8 @@ func (node *Node) FlushOutbox(maxAgeSeconds int64) {
 			events.Warning(node, "FlushOutbox failure accessing a path:", path, err.Error())
 			return err
 		}
-		if !info.IsDir() {
-			if diff := now.Sub(info.ModTime()); diff > time.Duration(maxAgeSeconds)*time.Second {
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			if diff := now.Sub(info.ModTime()); diff > time.Duration(maxAgeSeconds)*time.Second || fileExpired(info.Name(), now.UnixNano()) {
 				events.Debug(node, "Deleting file:", filepath.Join(node.basePath, info.Name()), diff)
 				if err = os.Remove(path); err != nil {
 					events.Error(node, "error deleting file: "+err.Error())
@@ -126,6 +233,30 @@ func (node *Node) FlushOutbox(maxAgeSeconds int64) {
 	})
 }
 
+// QueueStats : Returns the current outbox and stream reassembly backlog
+func (node *Node) QueueStats() (api.QueueStats, error) {
+	var stats api.QueueStats
+	err := filepath.Walk(node.basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			stats.OutboxMsgs++
+			stats.OutboxBytes += info.Size()
+		}
+		return nil
+	})
+	node.streamMtx.Lock()
+	for id, stream := range node.streams {
+		if stream != nil {
+			stats.Streams++
+			stats.Chunks += len(node.chunks[id])
+		}
+	}
+	node.streamMtx.Unlock()
+	return stats, err
+}
+
 // Channels
 
 // In : Returns the In channel of this node
@@ -2,21 +2,29 @@ package fs
 
 import (
 	"bytes"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/awgh/bencrypt/ecc"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/router"
 )
 
 var (
 	node *Node
+	dir  string // removed again by Test_stop
 )
 
 func Test_init(t *testing.T) {
-	node = New(new(ecc.KeyPair), new(ecc.KeyPair), "tmp")
-	os.Mkdir("tmp", os.FileMode(int(0755)))
+	var err error
+	if dir, err = ioutil.TempDir("", "ratnet-fs"); err != nil {
+		log.Fatal(err)
+	}
+	node = New(new(ecc.KeyPair), new(ecc.KeyPair), dir)
 	node.FlushOutbox(0)
 	if err := node.routingKey.FromB64(pubprivkeyb64Ecc); err != nil {
 		log.Fatal(err)
@@ -71,8 +79,115 @@ func Test_apicall_Channels_1(t *testing.T) {
 	t.Log(message)
 }
 
+func Test_PeerStats_Persist_1(t *testing.T) {
+	if err := node.SetPeerStats(api.PeerStats{Host: "https://a:20001", LastPollLocal: 10, LastPollRemote: 20}); err != nil {
+		t.Fatal(err)
+	}
+	restarted := New(new(ecc.KeyPair), new(ecc.KeyPair), dir)
+	stats, err := restarted.GetPeerStats("https://a:20001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].LastPollLocal != 10 || stats[0].LastPollRemote != 20 {
+		t.Fatalf("peer stats were not reloaded: %+v", stats)
+	}
+	if err := node.ResetPeerStats(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Replay_Persist_1(t *testing.T) {
+	if err := node.FlushSeenNonces(time.Now().UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	nonce := bytes.Repeat([]byte{5}, 32)
+	r := router.NewDefaultRouter()
+	r.PersistReplay = true
+	if err := r.SetReplayStore(node); err != nil {
+		t.Fatal(err)
+	}
+	if r.SeenRecently(nonce) {
+		t.Fatal("new nonce reported as seen")
+	}
+	if err := r.Persist(nonce); err != nil {
+		t.Fatal(err)
+	}
+	// a new node on the same directory, as after a restart
+	store := New(new(ecc.KeyPair), new(ecc.KeyPair), dir)
+	restarted := router.NewDefaultRouter()
+	restarted.PersistReplay = true
+	if err := restarted.SetReplayStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.SeenRecently(nonce) {
+		t.Fatal("replayed nonce accepted after a restart")
+	}
+}
+
+func Test_Replay_Truncated_1(t *testing.T) {
+	store := New(new(ecc.KeyPair), new(ecc.KeyPair), dir)
+	if err := store.FlushSeenNonces(time.Now().UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UnixNano()
+	if err := store.AddSeenNonce(bytes.Repeat([]byte{6}, 32), now); err != nil {
+		t.Fatal(err)
+	}
+	// a crash in the middle of an append leaves half a record
+	f, err := os.OpenFile(filepath.Join(dir, replayFile), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 32, 7, 7}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	restarted := New(new(ecc.KeyPair), new(ecc.KeyPair), dir)
+	r := router.NewDefaultRouter()
+	r.PersistReplay = true
+	if err := r.SetReplayStore(restarted); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.AddSeenNonce(bytes.Repeat([]byte{8}, 32), now+1); err != nil {
+		t.Fatal(err)
+	}
+	seen, err := restarted.GetSeenNonces(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || seen[0].Timestamp != now || seen[1].Timestamp != now+1 {
+		t.Fatalf("replay file was not truncated to its last complete record: %+v", seen)
+	}
+	if err := restarted.FlushSeenNonces(now + 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_apicall_Pickup_TooBig_1(t *testing.T) {
+	node.FlushOutbox(0)
+	big := api.Msg{Name: "big", IsChan: true, Content: bytes.NewBuffer(bytes.Repeat([]byte{1}, 1024))}
+	if err := node.Forward(big); err != nil {
+		t.Fatal(err)
+	}
+	rpk, err := node.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the only message can never fit, the cursor has to move past it or the peer is stuck
+	bundle, err := node.Pickup(rpk, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Data) != 0 || bundle.Time == 0 {
+		t.Fatalf("oversized message was not skipped: %d bytes, cursor %d", len(bundle.Data), bundle.Time)
+	}
+	node.FlushOutbox(0)
+}
+
 func Test_stop(t *testing.T) {
 	node.Stop()
+	os.RemoveAll(dir)
 }
 
 // Test Messages
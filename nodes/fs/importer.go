@@ -107,7 +107,13 @@ func (node *Node) Import(jsonConfig []byte) error {
 		node.profiles[cp.Name] = cp
 	}
 
-	node.SetRouter(ratnet.NewRouterFromMap(nj.Router))
+	if len(nj.Router) > 0 {
+		router, err := ratnet.NewRouterFromMap(nj.Router)
+		if err != nil {
+			return err
+		}
+		node.SetRouter(router)
+	}
 	for _, p := range nj.Policies {
 		// extract the inner Transport first
 		t := p["Transport"].(map[string]interface{})
@@ -3,8 +3,12 @@ package fs
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -23,6 +27,22 @@ func (node *Node) GetChannelPrivKey(name string) (string, error) {
 	return c.Privkey.ToB64(), nil
 }
 
+// RecryptChannel : Returns content encrypted to channel from, encrypted again to channel to
+func (node *Node) RecryptChannel(from, to string, content []byte) ([]byte, error) {
+	src, ok := node.channels[from]
+	dst, ok2 := node.channels[to]
+	if !ok || !ok2 || src.Privkey == nil || dst.Privkey == nil {
+		return nil, errors.New("Channel not found")
+	}
+	tagOK, clear, err := src.Privkey.DecryptMessage(content)
+	if err != nil {
+		return nil, err
+	} else if !tagOK {
+		return nil, errors.New("Message is not for channel " + from)
+	}
+	return node.contentKey.EncryptMessage(clear, dst.Privkey.GetPubKey())
+}
+
 // Forward - Add an already-encrypted message to the outbound message queue (forward it along)
 func (node *Node) Forward(msg api.Msg) error {
 
@@ -36,7 +56,16 @@ func (node *Node) Forward(msg api.Msg) error {
 	if msg.StreamHeader {
 		flags |= api.StreamHeaderFlag
 	}
+	if msg.HopLimit > 0 {
+		flags |= api.HopLimitFlag
+	}
+	if msg.Receipt {
+		flags |= api.ReceiptFlag
+	}
 	rxsum := []byte{flags} // prepend flags byte
+	if msg.HopLimit > 0 {
+		rxsum = append(rxsum, msg.HopLimit)
+	}
 	m := new(outboxMsg)
 	path := node.basePath
 	if msg.IsChan {
@@ -68,6 +97,7 @@ func (node *Node) Forward(msg api.Msg) error {
 	w.Write(message)
 	w.Flush()
 
+	events.Emit(node, api.Debug, api.MessageForwarded, api.MessageEvent{Channel: msg.Name, IsChan: msg.IsChan, Size: len(message)})
 	return nil
 }
 
@@ -95,6 +125,16 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 	}
 	clearMsg.Content = bytes.NewBuffer(clear)
 
+	// direct messages may carry a delivery receipt request, or be a receipt
+	if msg.Receipt && !msg.IsChan {
+		var deliver bool
+		if clearMsg, deliver, err = node.receipts.Handle(clearMsg); !deliver {
+			return true, err
+		} else if err != nil {
+			events.Warning(node, "could not send delivery receipt: "+err.Error())
+		}
+	}
+
 	if msg.Chunked {
 		err = chunking.HandleChunked(node, clearMsg)
 		if err != nil {
@@ -106,6 +146,7 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 	select {
 	case node.Out() <- clearMsg:
 		events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+		events.Emit(node, api.Info, api.MessageReceived, api.MessageEvent{Channel: msg.Name, IsChan: msg.IsChan, Size: clearMsg.Content.Len()})
 	default:
 		events.Debug(node, "No message sent")
 	}
@@ -132,7 +173,9 @@ func (node *Node) AddStream(streamID uint32, totalChunks uint32, channelName str
 	stream.StreamID = streamID
 	stream.NumChunks = totalChunks
 	stream.ChannelName = channelName
+	node.streamMtx.Lock()
 	node.streams[streamID] = stream
+	node.streamMtx.Unlock()
 	return nil
 }
 
@@ -142,9 +185,175 @@ func (node *Node) AddChunk(streamID uint32, chunkNum uint32, data []byte) error
 	chunk.StreamID = streamID
 	chunk.ChunkNum = chunkNum
 	chunk.Data = data
+	node.streamMtx.Lock()
+	defer node.streamMtx.Unlock()
 	if node.chunks[streamID] == nil {
 		node.chunks[streamID] = make(map[uint32]*api.Chunk)
 	}
 	node.chunks[streamID][chunkNum] = chunk
 	return nil
 }
+
+// AddSeenNonce - implemented from ReplayStore API
+func (node *Node) AddSeenNonce(nonce []byte, timestamp int64) error {
+	node.replayMtx.Lock()
+	defer node.replayMtx.Unlock()
+	if node.replayOut == nil {
+		f, err := os.OpenFile(filepath.Join(node.basePath, replayFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		node.replayOut = f
+	}
+	// one write per record, so a record is never split between appends
+	var b bytes.Buffer
+	writeSeenNonce(&b, api.SeenNonce{Nonce: nonce, Timestamp: timestamp})
+	_, err := node.replayOut.Write(b.Bytes())
+	return err
+}
+
+// closeReplayFile - closes the replay file if it is open for appending, the caller must hold replayMtx
+func (node *Node) closeReplayFile() error {
+	if node.replayOut == nil {
+		return nil
+	}
+	err := node.replayOut.Close()
+	node.replayOut = nil
+	return err
+}
+
+// GetSeenNonces - implemented from ReplayStore API
+func (node *Node) GetSeenNonces(since int64) ([]api.SeenNonce, error) {
+	node.replayMtx.Lock()
+	defer node.replayMtx.Unlock()
+	all, err := node.readSeenNonces()
+	if err != nil {
+		return nil, err
+	}
+	var seen []api.SeenNonce
+	for _, s := range all {
+		if s.Timestamp > since {
+			seen = append(seen, s)
+		}
+	}
+	return seen, nil
+}
+
+// FlushSeenNonces - implemented from ReplayStore API
+func (node *Node) FlushSeenNonces(before int64) error {
+	node.replayMtx.Lock()
+	defer node.replayMtx.Unlock()
+	all, err := node.readSeenNonces()
+	if err != nil {
+		return err
+	}
+	// rewrite the file without the expired entries, then swap it in
+	tmpPath := filepath.Join(node.basePath, replayFile+".tmp")
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, s := range all {
+		if s.Timestamp >= before {
+			writeSeenNonce(w, s)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// the open handle would keep appending to the replaced file
+	if err := node.closeReplayFile(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(node.basePath, replayFile))
+}
+
+// readSeenNonces - reads every record in the replay file, the caller must hold replayMtx;
+// a record cut short by a crash is truncated away, so appends start at a record boundary again
+func (node *Node) readSeenNonces() ([]api.SeenNonce, error) {
+	path := filepath.Join(node.basePath, replayFile)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var seen []api.SeenNonce
+	var size int64 // of the complete records read so far
+	for {
+		var s api.SeenNonce
+		var l uint16
+		if err := binary.Read(r, binary.BigEndian, &s.Timestamp); err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			return seen, os.Truncate(path, size)
+		} else if err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &l); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return seen, os.Truncate(path, size)
+		} else if err != nil {
+			return nil, err
+		}
+		s.Nonce = make([]byte, l)
+		if _, err := io.ReadFull(r, s.Nonce); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return seen, os.Truncate(path, size)
+		} else if err != nil {
+			return nil, err
+		}
+		seen = append(seen, s)
+		size += 8 + 2 + int64(l)
+	}
+	return seen, nil
+}
+
+// writeSeenNonce - replay file records are a timestamp, a uint16 nonce length, and the nonce, all big-endian
+func writeSeenNonce(w io.Writer, s api.SeenNonce) {
+	binary.Write(w, binary.BigEndian, s.Timestamp)
+	binary.Write(w, binary.BigEndian, uint16(len(s.Nonce)))
+	w.Write(s.Nonce)
+}
+
+// loadPeerStats - reads the peer stats file, if there is one
+func (node *Node) loadPeerStats() error {
+	node.peerStatsMtx.Lock()
+	defer node.peerStatsMtx.Unlock()
+	b, err := ioutil.ReadFile(filepath.Join(node.basePath, peerStatsFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var stats []api.PeerStats
+	if err := json.Unmarshal(b, &stats); err != nil {
+		return err
+	}
+	for i := range stats {
+		node.peerStats[stats[i].Host] = &stats[i]
+	}
+	return nil
+}
+
+// savePeerStats - rewrites the peer stats file, the caller must hold peerStatsMtx
+func (node *Node) savePeerStats() error {
+	stats := make([]api.PeerStats, 0, len(node.peerStats))
+	for _, s := range node.peerStats {
+		stats = append(stats, *s)
+	}
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(node.basePath, peerStatsFile+".tmp")
+	if err := ioutil.WriteFile(tmpPath, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(node.basePath, peerStatsFile))
+}
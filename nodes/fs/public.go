@@ -4,10 +4,11 @@ import (
 	"bytes"
 	"encoding/gob"
 	"errors"
-	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
@@ -19,6 +20,19 @@ func (node *Node) ID() (bc.PubKey, error) {
 	return node.routingKey.GetPubKey(), nil
 }
 
+// GetPeerList : Return the peers this node shares, signed with the routing key if it can sign
+func (node *Node) GetPeerList() (*api.PeerList, error) {
+	peers, err := node.GetPeers(api.SharedPeerGroup)
+	if err != nil {
+		return nil, err
+	}
+	list := api.NewPeerList(node.routingKey.GetPubKey(), peers)
+	if err := list.Sign(node.routingKey); err != nil && err != api.ErrCannotSign {
+		return nil, err
+	}
+	return list, nil
+}
+
 // Dropoff : Deliver a batch of  messages to a remote node
 func (node *Node) Dropoff(bundle api.Bundle) error {
 	events.Debug(node, "Dropoff called")
@@ -61,41 +75,44 @@ func (node *Node) Pickup(rpub bc.PubKey, lastTime int64, maxBytes int64, channel
 	events.Debug(node, "Pickup called")
 	var retval api.Bundle
 	var msgs [][]byte
-	retval.Time = lastTime
-	var bytesRead int64
+	var paths []string
+	var entries []api.PickupEntry
+	now := time.Now().UnixNano()
 
 	err := filepath.Walk(node.basePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			events.Error(node, "Pickup failure accessing a path:", path, err)
 			return err
 		}
+		if strings.HasPrefix(info.Name(), ".") { // not an outbox message
+			return nil
+		}
 		fileTime := info.ModTime().UnixNano()
-		if !info.IsDir() && fileTime > lastTime {
-			b, err := ioutil.ReadFile(path) //filepath.Join(node.basePath, path))
-			if err != nil {
-				events.Error(node, "prevent panic by handling failure reading a file:", path, err)
-				return err
-			}
-
-			if fileTime == retval.Time {
-				events.Warning(node, "Identical filetimes, attempting to exceed recommended protocol buffer size")
-			} else if bytesRead+int64(len(b)) >= maxBytes { // no room for next msg
-				events.Warning(node, "Result too big to be fetched on this transport! Flush and rechunk")
-				return io.EOF
-			}
-
-			msgs = append(msgs, b)
-			bytesRead += int64(len(b))
-			if fileTime > retval.Time {
-				retval.Time = fileTime
-			}
+		if !info.IsDir() && fileTime > lastTime && !fileExpired(info.Name(), now) {
+			_, priority := parseOutboxFileName(info.Name())
+			paths = append(paths, path)
+			entries = append(entries, api.PickupEntry{Size: info.Size(), Timestamp: fileTime, Priority: priority})
 		}
 		return nil
 	})
-	if err != nil && err != io.EOF {
+	if err != nil {
 		return retval, err
 	}
 
+	chosen, cursor, tooBig := api.PackPickup(entries, lastTime, maxBytes)
+	if tooBig {
+		events.Warning(node, "Result too big to be fetched on this transport! Flush and rechunk")
+	}
+	retval.Time = cursor
+	for _, c := range chosen {
+		b, err := ioutil.ReadFile(paths[c])
+		if err != nil {
+			events.Error(node, "prevent panic by handling failure reading a file:", paths[c], err)
+			return retval, err
+		}
+		msgs = append(msgs, b)
+	}
+
 	// transmit
 	if len(msgs) > 0 {
 
@@ -0,0 +1,54 @@
+package nodes
+
+import (
+	"errors"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// SwapPolicies : For a running node, stops the old policies that are not among the new ones and starts the new ones that are not running
+func SwapPolicies(node api.Node, old, new []api.Policy) {
+	for _, p := range old {
+		if !hasPolicy(new, p) {
+			p.Stop()
+		}
+	}
+	for _, p := range new {
+		if p.Status().State == api.StateRunning {
+			continue
+		}
+		if err := p.RunPolicy(); err != nil {
+			events.Error(node, "Policy "+p.Status().Policy+" failed to start: "+err.Error())
+		}
+	}
+}
+
+// RemovePolicy : Stops policy and returns policies without it, an error if it is not one of them
+func RemovePolicy(policies []api.Policy, policy api.Policy) ([]api.Policy, error) {
+	for i, p := range policies {
+		if p == policy {
+			p.Stop()
+			return append(policies[:i:i], policies[i+1:]...), nil
+		}
+	}
+	return policies, errors.New("Policy not found")
+}
+
+// PolicyStatus : Returns the status of each policy, in the same order
+func PolicyStatus(policies []api.Policy) []api.PolicyStatus {
+	status := make([]api.PolicyStatus, len(policies))
+	for i, p := range policies {
+		status[i] = p.Status()
+	}
+	return status
+}
+
+func hasPolicy(policies []api.Policy, policy api.Policy) bool {
+	for _, p := range policies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
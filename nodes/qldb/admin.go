@@ -11,6 +11,7 @@ import (
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/chunking"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/receipts"
 )
 
 // CID : Return content key
@@ -144,6 +145,17 @@ func (node *Node) DeletePeer(name string) error {
 	return nil
 }
 
+// GetPeerStats : Return the poll statistics of the given peer hosts, or of all of them if none are given
+func (node *Node) GetPeerStats(host ...string) ([]api.PeerStats, error) {
+	return node.qlGetPeerStats(host)
+}
+
+// ResetPeerStats : Forget the poll statistics of the given peer hosts, or of all of them if none are given
+func (node *Node) ResetPeerStats(host ...string) error {
+	node.qlResetPeerStats(host)
+	return nil
+}
+
 // Send : Transmit a message to a single key
 func (node *Node) Send(contactName string, data []byte, pubkey ...bc.PubKey) error {
 	var destkey bc.PubKey
@@ -187,14 +199,31 @@ func (node *Node) SendChannel(channelName string, data []byte, pubkey ...bc.PubK
 // SendMsg : Transmits a message
 func (node *Node) SendMsg(msg api.Msg) error {
 
+	// wrap the content in a delivery receipt request, if one was asked for
+	if msg.ReceiptID != nil {
+		var err error
+		if msg, err = receipts.Request(node, msg); err != nil {
+			return err
+		}
+	}
+
 	// determine if we need to chunk
 	chunkSize := chunking.ChunkSize(node)                               // finds the minimum transport byte limit
 	if msg.Content.Len() > 0 && uint32(msg.Content.Len()) > chunkSize { // we need to chunk
 		if msg.Chunked { // we're already chunked, freak out!
 			return errors.New("Chunked message needs to be chunked, bailing out")
 		}
+		if msg.Receipt {
+			return errors.New("Delivery receipts are not supported for chunked messages")
+		}
 		return chunking.SendChunked(node, chunkSize, msg)
 	}
+	if msg.ReceiptID != nil {
+		node.receipts.Track(msg)
+	} else if !msg.IsChan && !msg.Receipt {
+		msg = receipts.Plain(msg)
+	}
+
 	data, err := node.contentKey.EncryptMessage(msg.Content.Bytes(), msg.PubKey)
 	if err != nil {
 		return err
@@ -210,7 +239,16 @@ func (node *Node) SendMsg(msg api.Msg) error {
 	if msg.StreamHeader {
 		flags |= api.StreamHeaderFlag
 	}
+	if msg.HopLimit > 0 {
+		flags |= api.HopLimitFlag
+	}
+	if msg.Receipt {
+		flags |= api.ReceiptFlag
+	}
 	rxsum := []byte{flags} // prepend flags byte
+	if msg.HopLimit > 0 {
+		rxsum = append(rxsum, msg.HopLimit)
+	}
 
 	if msg.IsChan {
 		// prepend a uint16 of channel name length, little-endian
@@ -221,9 +259,9 @@ func (node *Node) SendMsg(msg api.Msg) error {
 	data = append(rxsum, data...)
 	ts := time.Now().UnixNano()
 	if msg.IsChan {
-		return node.qlOutboxEnqueue(msg.Name, data, ts, false)
+		return node.qlOutboxEnqueue(msg.Name, data, ts, msg.Expires, msg.Priority, false)
 	}
-	return node.qlOutboxEnqueue("", data, ts, false)
+	return node.qlOutboxEnqueue("", data, ts, msg.Expires, msg.Priority, false)
 }
 
 // SendBulk : Transmit messages to a single key
@@ -310,15 +348,24 @@ func (node *Node) Start() error {
 
 	// start the signal monitor
 	node.signalMonitor()
+	node.receipts.Start()
+
+	// reload loop detection state
+	if r, ok := node.router.(api.PersistentRouter); ok && node.db != nil {
+		if err := r.SetReplayStore(node); err != nil {
+			return err
+		}
+	}
 
 	// start the policies
-	if node.policies != nil {
-		for i := 0; i < len(node.policies); i++ {
-			if err := node.policies[i].RunPolicy(); err != nil {
-				return err
-			}
+	node.policyMtx.Lock()
+	for _, policy := range node.policies {
+		if err := policy.RunPolicy(); err != nil {
+			node.policyMtx.Unlock()
+			return err
 		}
 	}
+	node.policyMtx.Unlock()
 
 	// input loop
 	go func() {
@@ -378,6 +425,8 @@ func (node *Node) Start() error {
 					select {
 					case node.Out() <- msg:
 						events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+						events.Emit(node, api.Info, api.StreamCompleted, api.StreamEvent{StreamID: stream.StreamID, Channel: stream.ChannelName, NumChunks: stream.NumChunks})
+						events.Emit(node, api.Info, api.MessageReceived, api.MessageEvent{Channel: msg.Name, IsChan: msg.IsChan, Size: msg.Content.Len()})
 						node.qlClearStream(stream.StreamID)
 					default:
 						events.Debug(node, "No message sent")
@@ -392,10 +441,13 @@ func (node *Node) Start() error {
 
 // Stop : sets the isRunning flag to false, indicating that all go routines should end
 func (node *Node) Stop() {
+	node.receipts.Stop()
 	node.isRunning = false
+	node.policyMtx.Lock()
 	for _, policy := range node.policies {
 		policy.Stop()
 	}
+	node.policyMtx.Unlock()
 
 	close(node.in)
 	close(node.out)
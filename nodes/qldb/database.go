@@ -374,7 +374,68 @@ func (node *Node) qlDeletePeer(name string) {
 	node.transactExec("DELETE FROM peers WHERE name==$1;", name)
 }
 
-func (node *Node) qlOutboxEnqueue(channelName string, msg []byte, ts int64, checkExists bool) error {
+func (node *Node) qlGetPeerStats(hosts []string) ([]api.PeerStats, error) {
+	c := node.db()
+	defer closeDB(c)
+	sqlq := "SELECT host,lastpolllocal,lastpollremote,lastsuccess,lastfailure,lasterror,polls,failures,bytestx,bytesrx FROM peerstats"
+	var args []interface{}
+	if len(hosts) > 0 {
+		sqlq += " WHERE host IN ($1"
+		args = append(args, hosts[0])
+		for i := 1; i < len(hosts); i++ {
+			sqlq += ",$" + strconv.Itoa(i+1)
+			args = append(args, hosts[i])
+		}
+		sqlq += ")"
+	}
+	sqlq += " ORDER BY host;"
+	events.Info(node, sqlq, args)
+	r, err := c.Query(sqlq, args...)
+	if r == nil || err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var stats []api.PeerStats
+	for r.Next() {
+		var s api.PeerStats
+		if err := r.Scan(&s.Host, &s.LastPollLocal, &s.LastPollRemote, &s.LastSuccess, &s.LastFailure, &s.LastError,
+			&s.Polls, &s.Failures, &s.TotalBytesTX, &s.TotalBytesRX); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+func (node *Node) qlSetPeerStats(s api.PeerStats) error {
+	c := node.db()
+	defer closeDB(c)
+	sqlq := "SELECT host FROM peerstats WHERE host==$1;"
+	events.Info(node, sqlq, s.Host)
+	r := c.QueryRow(sqlq, s.Host)
+	var h string
+	if err := r.Scan(&h); err == sql.ErrNoRows {
+		node.transactExec("INSERT INTO peerstats (host,lastpolllocal,lastpollremote,lastsuccess,lastfailure,lasterror,polls,failures,bytestx,bytesrx) VALUES( $1, $2, $3, $4, $5, $6, $7, $8, $9, $10 );",
+			s.Host, s.LastPollLocal, s.LastPollRemote, s.LastSuccess, s.LastFailure, s.LastError, s.Polls, s.Failures, s.TotalBytesTX, s.TotalBytesRX)
+	} else if err == nil {
+		node.transactExec("UPDATE peerstats SET lastpolllocal=$1,lastpollremote=$2,lastsuccess=$3,lastfailure=$4,lasterror=$5,polls=$6,failures=$7,bytestx=$8,bytesrx=$9 WHERE host==$10;",
+			s.LastPollLocal, s.LastPollRemote, s.LastSuccess, s.LastFailure, s.LastError, s.Polls, s.Failures, s.TotalBytesTX, s.TotalBytesRX, s.Host)
+	} else {
+		return err
+	}
+	return nil
+}
+
+func (node *Node) qlResetPeerStats(hosts []string) {
+	if len(hosts) == 0 {
+		node.transactExec("DELETE FROM peerstats;")
+	}
+	for _, host := range hosts {
+		node.transactExec("DELETE FROM peerstats WHERE host==$1;", host)
+	}
+}
+
+func (node *Node) qlOutboxEnqueue(channelName string, msg []byte, ts, expires int64, priority uint8, checkExists bool) error {
 
 	doInsert := !checkExists
 
@@ -395,8 +456,8 @@ func (node *Node) qlOutboxEnqueue(channelName string, msg []byte, ts int64, chec
 		}
 	}
 	if doInsert {
-		node.transactExec("INSERT INTO outbox(channel,msg,timestamp) VALUES($1,$2,$3);",
-			channelName, msg, ts)
+		node.transactExec("INSERT INTO outbox(channel,msg,timestamp,expires,priority) VALUES($1,$2,$3,$4,$5);",
+			channelName, msg, ts, expires, int64(priority))
 	}
 	return nil
 }
@@ -456,17 +517,14 @@ func (node *Node) qlGetMessages(lastTime, maxBytes int64, channelNames ...string
 			}
 		}
 	}
-	sqlq := "SELECT msg, timestamp FROM outbox"
+	sqlq := "SELECT msg, timestamp, priority FROM outbox WHERE (expires IS NULL OR expires == 0 OR int64(" +
+		strconv.FormatInt(time.Now().UnixNano(), 10) + ") < expires)"
 	if lastTime != 0 {
-		sqlq += " WHERE (int64(" + strconv.FormatInt(lastTime, 10) +
+		sqlq += " AND (int64(" + strconv.FormatInt(lastTime, 10) +
 			") < timestamp)"
 	}
 	if !wildcard && len(channelNames) > 0 { // QL is broken?  couldn't make it work with prepared stmts
-		if lastTime != 0 {
-			sqlq += " AND"
-		} else {
-			sqlq += " WHERE"
-		}
+		sqlq += " AND"
 		sqlq = sqlq + " channel IN( \"" + channelNames[0] + "\""
 		for i := 1; i < len(channelNames); i++ {
 			sqlq = sqlq + ",\"" + channelNames[i] + "\""
@@ -476,7 +534,6 @@ func (node *Node) qlGetMessages(lastTime, maxBytes int64, channelNames ...string
 	sqlq = sqlq + " ORDER BY timestamp ASC;"
 
 	var msgs [][]byte
-	var bytesRead int64
 
 	r, err := c.Query(sqlq)
 	if r == nil || err != nil {
@@ -484,28 +541,27 @@ func (node *Node) qlGetMessages(lastTime, maxBytes int64, channelNames ...string
 	}
 	defer r.Close()
 
-	n := 0
+	var entries []api.PickupEntry
 	for r.Next() {
-		n++
 		var msg []byte
 		var ts int64
-		r.Scan(&msg, &ts)
-		if bytesRead+int64(len(msg)) >= maxBytes { // no room for next msg
-			events.Debug(node, "skipping messages after # results:", n)
-			if n == 0 {
-				return nil, lastTimeReturned, errors.New("Result too big to be fetched on this transport! Flush and rechunk")
-			}
-		}
-		if ts > lastTimeReturned {
-			lastTimeReturned = ts
-		} else {
-			events.Warning(node, "Timestamps not increasing - prev/cur:", lastTimeReturned, ts)
-		}
+		var priority sql.NullInt64 // NULL in rows from before the column was added
+		r.Scan(&msg, &ts, &priority)
 		msgs = append(msgs, msg)
-		bytesRead += int64(len(msg))
+		entries = append(entries, api.PickupEntry{Size: int64(len(msg)), Timestamp: ts, Priority: uint8(priority.Int64)})
 	}
 
-	return msgs, lastTimeReturned, nil
+	// fill the bundle by priority, then age
+	chosen, lastTimeReturned, tooBig := api.PackPickup(entries, lastTime, maxBytes)
+	if tooBig {
+		events.Warning(node, "Result too big to be fetched on this transport! Flush and rechunk")
+	}
+	picked := make([][]byte, len(chosen))
+	for i, c := range chosen {
+		picked[i] = msgs[c]
+	}
+	events.Debug(node, "picked up messages:", len(picked), "of", len(msgs))
+	return picked, lastTimeReturned, nil
 }
 
 // AddStream - implemented from Node API
@@ -614,13 +670,75 @@ func (node *Node) qlGetChunks(streamID uint32) ([]api.Chunk, error) {
 	return chunks, nil
 }
 
-// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds
+// FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds, or past their own deadline
 func (node *Node) FlushOutbox(maxAgeSeconds int64) {
-	ts := time.Now().UnixNano()
-	ts = ts - (maxAgeSeconds * 1000000000)
+	now := time.Now().UnixNano()
+	ts := now - (maxAgeSeconds * 1000000000)
 	sql := "DELETE FROM outbox WHERE timestamp < ($1);"
 	events.Info(node, "Flushed Database (seconds): ", maxAgeSeconds)
 	node.transactExec(sql, ts)
+	node.transactExec("DELETE FROM outbox WHERE expires > int64(0) AND expires < ($1);", now)
+}
+
+// QueueStats : Returns the current outbox and stream reassembly backlog
+func (node *Node) QueueStats() (api.QueueStats, error) {
+	var stats api.QueueStats
+	c := node.db()
+	defer closeDB(c)
+	r, err := c.Query("SELECT msg FROM outbox;") // ql's len() does not take blobs
+	if r == nil || err != nil {
+		return stats, err
+	}
+	defer r.Close()
+	for r.Next() {
+		var msg []byte
+		if err := r.Scan(&msg); err != nil {
+			return stats, err
+		}
+		stats.OutboxMsgs++
+		stats.OutboxBytes += int64(len(msg))
+	}
+	if err := c.QueryRow("SELECT count() FROM streams;").Scan(&stats.Streams); err != nil {
+		return stats, err
+	}
+	if err := c.QueryRow("SELECT count() FROM chunks;").Scan(&stats.Chunks); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// AddSeenNonce - implemented from ReplayStore API
+func (node *Node) AddSeenNonce(nonce []byte, timestamp int64) error {
+	node.transactExec("INSERT INTO seen(nonce,timestamp) VALUES($1,$2);", nonce, timestamp)
+	return nil
+}
+
+// GetSeenNonces - implemented from ReplayStore API
+func (node *Node) GetSeenNonces(since int64) ([]api.SeenNonce, error) {
+	c := node.db()
+	defer closeDB(c)
+	sqlq := "SELECT nonce, timestamp FROM seen WHERE (int64(" + strconv.FormatInt(since, 10) + ") < timestamp);"
+	events.Info(node, sqlq)
+	r, err := c.Query(sqlq)
+	if r == nil || err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var seen []api.SeenNonce
+	for r.Next() {
+		var s api.SeenNonce
+		if err := r.Scan(&s.Nonce, &s.Timestamp); err != nil {
+			return nil, err
+		}
+		seen = append(seen, s)
+	}
+	return seen, nil
+}
+
+// FlushSeenNonces - implemented from ReplayStore API
+func (node *Node) FlushSeenNonces(before int64) error {
+	node.transactExec("DELETE FROM seen WHERE timestamp < ($1);", before)
+	return nil
 }
 
 // BootstrapDB - Initialize or open a database file
@@ -669,12 +787,23 @@ func (node *Node) BootstrapDB(database string) func() *sql.DB {
 		CREATE TABLE IF NOT EXISTS outbox (
 			channel		string	DEFAULT "",
 			msg			blob	NOT NULL,
-			timestamp	int64	NOT NULL
+			timestamp	int64	NOT NULL,
+			expires		int64	DEFAULT 0,
+			priority	int64	DEFAULT 0
 		);
 	`)
 	node.transactExec(`
 			CREATE INDEX IF NOT EXISTS outboxID ON outbox (timestamp);
 	`)
+	// databases created before message deadlines and priorities need the columns added
+	cols := node.db()
+	for _, col := range []string{"expires", "priority"} {
+		var hasCol int
+		if err := cols.QueryRow(`SELECT count(*) FROM __Column WHERE TableName == "outbox" AND Name == $1;`, col).Scan(&hasCol); err == nil && hasCol == 0 {
+			node.transactExec(`ALTER TABLE outbox ADD ` + col + ` int64;`)
+		}
+	}
+	closeDB(cols)
 
 	node.transactExec(`
 		CREATE TABLE IF NOT EXISTS peers (
@@ -710,6 +839,30 @@ func (node *Node) BootstrapDB(database string) func() *sql.DB {
 	);
 	`)
 
+	node.transactExec(`
+	CREATE TABLE IF NOT EXISTS peerstats (
+		host			string	NOT NULL,
+		lastpolllocal	int64	NOT NULL,
+		lastpollremote	int64	NOT NULL,
+		lastsuccess		int64	NOT NULL,
+		lastfailure		int64	NOT NULL,
+		lasterror		string	NOT NULL,
+		polls			int64	NOT NULL,
+		failures		int64	NOT NULL,
+		bytestx			int64	NOT NULL,
+		bytesrx			int64	NOT NULL
+	);
+	`)
+	node.transactExec(`
+	CREATE TABLE IF NOT EXISTS seen (
+		nonce			blob	NOT NULL,
+		timestamp		int64	NOT NULL
+	);
+	`)
+	node.transactExec(`
+			CREATE INDEX IF NOT EXISTS seenID ON seen (timestamp);
+	`)
+
 	var n, s string
 	c := node.db()
 	defer closeDB(c)
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/awgh/bencrypt/bc"
@@ -11,6 +12,7 @@ import (
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/chunking"
 	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/api/receipts"
 )
 
 // CID : Return content key
@@ -221,6 +223,38 @@ func (node *Node) DeletePeer(name string) error {
 	return nil
 }
 
+// GetPeerStats : Return the poll statistics of the given peer hosts, or of all of them if none are given
+func (node *Node) GetPeerStats(host ...string) ([]api.PeerStats, error) {
+	node.peerStatsMtx.Lock()
+	defer node.peerStatsMtx.Unlock()
+	if len(host) == 0 {
+		for h := range node.peerStats {
+			host = append(host, h)
+		}
+		sort.Strings(host)
+	}
+	var stats []api.PeerStats
+	for _, h := range host {
+		if s, ok := node.peerStats[h]; ok {
+			stats = append(stats, *s)
+		}
+	}
+	return stats, nil
+}
+
+// ResetPeerStats : Forget the poll statistics of the given peer hosts, or of all of them if none are given
+func (node *Node) ResetPeerStats(host ...string) error {
+	node.peerStatsMtx.Lock()
+	defer node.peerStatsMtx.Unlock()
+	if len(host) == 0 {
+		node.peerStats = make(map[string]*api.PeerStats)
+	}
+	for _, h := range host {
+		delete(node.peerStats, h)
+	}
+	return nil
+}
+
 // Send : Transmit a message to a single key
 func (node *Node) Send(contactName string, data []byte, pubkey ...bc.PubKey) error {
 	var destkey bc.PubKey
@@ -267,14 +301,30 @@ func (node *Node) SendChannel(channelName string, data []byte, pubkey ...bc.PubK
 // SendMsg : Transmits a message
 func (node *Node) SendMsg(msg api.Msg) error {
 
+	// wrap the content in a delivery receipt request, if one was asked for
+	if msg.ReceiptID != nil {
+		var err error
+		if msg, err = receipts.Request(node, msg); err != nil {
+			return err
+		}
+	}
+
 	// determine if we need to chunk
 	chunkSize := chunking.ChunkSize(node)                               // finds the minimum transport byte limit
 	if msg.Content.Len() > 0 && uint32(msg.Content.Len()) > chunkSize { // we need to chunk
 		if msg.Chunked { // we're already chunked, freak out!
 			return errors.New("Chunked message needs to be chunked, bailing out")
 		}
+		if msg.Receipt {
+			return errors.New("Delivery receipts are not supported for chunked messages")
+		}
 		return chunking.SendChunked(node, chunkSize, msg)
 	}
+	if msg.ReceiptID != nil {
+		node.receipts.Track(msg)
+	} else if !msg.IsChan && !msg.Receipt {
+		msg = receipts.Plain(msg)
+	}
 
 	data, err := node.contentKey.EncryptMessage(msg.Content.Bytes(), msg.PubKey)
 	if err != nil {
@@ -291,7 +341,16 @@ func (node *Node) SendMsg(msg api.Msg) error {
 	if msg.StreamHeader {
 		flags |= api.StreamHeaderFlag
 	}
+	if msg.HopLimit > 0 {
+		flags |= api.HopLimitFlag
+	}
+	if msg.Receipt {
+		flags |= api.ReceiptFlag
+	}
 	rxsum := []byte{flags} // prepend flags byte
+	if msg.HopLimit > 0 {
+		rxsum = append(rxsum, msg.HopLimit)
+	}
 
 	if msg.IsChan {
 		// prepend a uint16 of channel name length, little-endian
@@ -307,6 +366,8 @@ func (node *Node) SendMsg(msg api.Msg) error {
 		m.channel = msg.Name
 	}
 	m.timeStamp = ts
+	m.expires = msg.Expires
+	m.priority = msg.Priority
 	m.msg = data
 	node.outbox.Append(m)
 	return nil
@@ -331,15 +392,17 @@ func (node *Node) Start() error {
 
 	// start the signal monitor
 	node.signalMonitor()
+	node.receipts.Start()
 
 	// start the policies
-	if node.policies != nil {
-		for i := 0; i < len(node.policies); i++ {
-			if err := node.policies[i].RunPolicy(); err != nil {
-				return err
-			}
+	node.policyMtx.Lock()
+	for _, policy := range node.policies {
+		if err := policy.RunPolicy(); err != nil {
+			node.policyMtx.Unlock()
+			return err
 		}
 	}
+	node.policyMtx.Unlock()
 
 	node.isRunning = true
 
@@ -369,6 +432,7 @@ func (node *Node) Start() error {
 				break
 			}
 			// for each stream, count chunks for that header
+			node.streamMtx.Lock()
 			for _, stream := range node.streams {
 				count := 0
 				if stream != nil {
@@ -397,6 +461,8 @@ func (node *Node) Start() error {
 						select {
 						case node.Out() <- msg:
 							events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+							events.Emit(node, api.Info, api.StreamCompleted, api.StreamEvent{StreamID: stream.StreamID, Channel: stream.ChannelName, NumChunks: stream.NumChunks})
+							events.Emit(node, api.Info, api.MessageReceived, api.MessageEvent{Channel: msg.Name, IsChan: msg.IsChan, Size: msg.Content.Len()})
 							node.streams[stream.StreamID] = nil
 							node.chunks[stream.StreamID] = make(map[uint32]*api.Chunk)
 						default:
@@ -405,6 +471,7 @@ func (node *Node) Start() error {
 					}
 				}
 			}
+			node.streamMtx.Unlock()
 		}
 	}()
 
@@ -413,8 +480,11 @@ func (node *Node) Start() error {
 
 // Stop : sets the isRunning flag to false, indicating that all go routines should end
 func (node *Node) Stop() {
+	node.receipts.Stop()
+	node.policyMtx.Lock()
 	for _, policy := range node.policies {
 		policy.Stop()
 	}
+	node.policyMtx.Unlock()
 	node.isRunning = false
 }
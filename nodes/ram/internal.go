@@ -22,6 +22,22 @@ func (node *Node) GetChannelPrivKey(name string) (string, error) {
 	return c.Privkey.ToB64(), nil
 }
 
+// RecryptChannel : Returns content encrypted to channel from, encrypted again to channel to
+func (node *Node) RecryptChannel(from, to string, content []byte) ([]byte, error) {
+	src, ok := node.channels[from]
+	dst, ok2 := node.channels[to]
+	if !ok || !ok2 || src.Privkey == nil || dst.Privkey == nil {
+		return nil, errors.New("Channel not found")
+	}
+	tagOK, clear, err := src.Privkey.DecryptMessage(content)
+	if err != nil {
+		return nil, err
+	} else if !tagOK {
+		return nil, errors.New("Message is not for channel " + from)
+	}
+	return node.contentKey.EncryptMessage(clear, dst.Privkey.GetPubKey())
+}
+
 // Forward - Add an already-encrypted message to the outbound message queue (forward it along)
 func (node *Node) Forward(msg api.Msg) error {
 
@@ -35,7 +51,16 @@ func (node *Node) Forward(msg api.Msg) error {
 	if msg.StreamHeader {
 		flags |= api.StreamHeaderFlag
 	}
+	if msg.HopLimit > 0 {
+		flags |= api.HopLimitFlag
+	}
+	if msg.Receipt {
+		flags |= api.ReceiptFlag
+	}
 	rxsum := []byte{flags} // prepend flags byte
+	if msg.HopLimit > 0 {
+		rxsum = append(rxsum, msg.HopLimit)
+	}
 	m := new(outboxMsg)
 	if msg.IsChan {
 		// prepend a uint16 of channel name length, little-endian
@@ -54,6 +79,7 @@ func (node *Node) Forward(msg api.Msg) error {
 	m.timeStamp = time.Now().UnixNano()
 	m.msg = message
 	node.outbox.Append(m)
+	events.Emit(node, api.Debug, api.MessageForwarded, api.MessageEvent{Channel: msg.Name, IsChan: msg.IsChan, Size: len(message)})
 	return nil
 }
 
@@ -83,6 +109,16 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 
 	clearMsg.Content = bytes.NewBuffer(clear)
 
+	// direct messages may carry a delivery receipt request, or be a receipt
+	if msg.Receipt && !msg.IsChan {
+		var deliver bool
+		if clearMsg, deliver, err = node.receipts.Handle(clearMsg); !deliver {
+			return true, err
+		} else if err != nil {
+			events.Warning(node, "could not send delivery receipt: "+err.Error())
+		}
+	}
+
 	if msg.Chunked {
 		err = chunking.HandleChunked(node, clearMsg)
 		if err != nil {
@@ -94,6 +130,7 @@ func (node *Node) Handle(msg api.Msg) (bool, error) {
 	select {
 	case node.Out() <- clearMsg:
 		events.Debug(node, "Sent message "+fmt.Sprint(msg.Content.Bytes()))
+		events.Emit(node, api.Info, api.MessageReceived, api.MessageEvent{Channel: msg.Name, IsChan: msg.IsChan, Size: clearMsg.Content.Len()})
 	default:
 		events.Debug(node, "No message sent")
 	}
@@ -120,7 +157,9 @@ func (node *Node) AddStream(streamID uint32, totalChunks uint32, channelName str
 	stream.StreamID = streamID
 	stream.NumChunks = totalChunks
 	stream.ChannelName = channelName
+	node.streamMtx.Lock()
 	node.streams[streamID] = stream
+	node.streamMtx.Unlock()
 	return nil
 }
 
@@ -130,6 +169,8 @@ func (node *Node) AddChunk(streamID uint32, chunkNum uint32, data []byte) error
 	chunk.StreamID = streamID
 	chunk.ChunkNum = chunkNum
 	chunk.Data = data
+	node.streamMtx.Lock()
+	defer node.streamMtx.Unlock()
 	if node.chunks[streamID] == nil {
 		node.chunks[streamID] = make(map[uint32]*api.Chunk)
 	}
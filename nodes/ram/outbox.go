@@ -15,6 +15,13 @@ type outboxMsg struct {
 	channel   string
 	msg       []byte
 	timeStamp int64
+	expires   int64 // UnixNano deadline, 0 for none
+	priority  uint8
+}
+
+// expired - returns whether this message has passed its deadline
+func (m *outboxMsg) expired(now int64) bool {
+	return m.expires != 0 && m.expires < now
 }
 
 type outboxQueue struct {
@@ -42,29 +49,43 @@ func (o *outboxQueue) MsgExists(channelName string, message []byte) bool {
 	return false
 }
 
-// Flush : Deletes outbound messages older than maxAgeSeconds seconds
+// Flush : Deletes outbound messages older than maxAgeSeconds seconds, or past their own deadline
 func (o *outboxQueue) Flush(maxAgeSeconds int64) {
-	c := (time.Now().UnixNano()) - (maxAgeSeconds * 1000000000)
+	now := time.Now().UnixNano()
+	c := now - (maxAgeSeconds * 1000000000)
 	o.mux.Lock()
-	for index, mail := range o.outbox {
-		if mail.timeStamp < c {
-			if len(o.outbox) > index {
-				o.outbox = append(o.outbox[:index], o.outbox[index+1:]...)
-			} else {
-				o.outbox = []*outboxMsg{}
-			}
+	kept := o.outbox[:0]
+	for _, mail := range o.outbox {
+		if mail.timeStamp >= c && !mail.expired(now) {
+			kept = append(kept, mail)
 		}
 	}
+	for i := len(kept); i < len(o.outbox); i++ {
+		o.outbox[i] = nil
+	}
+	o.outbox = kept
 	o.mux.Unlock()
 }
 
-// MsgsSince : Get messages after the given timestamp
+// Size : Returns the number of queued messages and their total size in bytes
+func (o *outboxQueue) Size() (int, int64) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	var total int64
+	for _, mail := range o.outbox {
+		total += int64(len(mail.msg))
+	}
+	return len(o.outbox), total
+}
+
+// MsgsSince : Get messages after the given timestamp, highest priority first
 func (o *outboxQueue) MsgsSince(lastTime int64, maxBytes int64, channelNames ...string) ([][]byte, int64) {
-	var msgs [][]byte
-	retvalTime := lastTime
+	var candidates []*outboxMsg
+	var entries []api.PickupEntry
+	now := time.Now().UnixNano()
 	o.mux.Lock()
 	for _, mail := range o.outbox {
-		if lastTime < mail.timeStamp {
+		if lastTime < mail.timeStamp && !mail.expired(now) {
 			pickupMsg := false
 			if len(channelNames) > 0 {
 				for _, channelName := range channelNames {
@@ -76,23 +97,20 @@ func (o *outboxQueue) MsgsSince(lastTime int64, maxBytes int64, channelNames ...
 				pickupMsg = true
 			}
 			if pickupMsg {
-				msgsSize := 0
-				for i := range msgs {
-					msgsSize += len(msgs[i])
-				}
-				proposedSize := len(mail.msg) + msgsSize
-
-				if maxBytes > 0 && int64(proposedSize) > maxBytes {
-					if msgsSize == 0 {
-						events.Critical(o.node, "Result too big to be fetched on this transport! Flush and rechunk")
-					}
-					break
-				}
-				retvalTime = mail.timeStamp
-				msgs = append(msgs, mail.msg)
+				candidates = append(candidates, mail)
+				entries = append(entries, api.PickupEntry{Size: int64(len(mail.msg)), Timestamp: mail.timeStamp, Priority: mail.priority})
 			}
 		}
 	}
 	o.mux.Unlock()
+
+	chosen, retvalTime, tooBig := api.PackPickup(entries, lastTime, maxBytes)
+	if tooBig {
+		events.Warning(o.node, "Result too big to be fetched on this transport! Flush and rechunk")
+	}
+	msgs := make([][]byte, len(chosen))
+	for i, c := range chosen {
+		msgs[i] = candidates[c].msg
+	}
 	return msgs, retvalTime
 }
@@ -0,0 +1,27 @@
+package ram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_outbox_Expires_1(t *testing.T) {
+	var o outboxQueue
+	now := time.Now().UnixNano()
+	o.Append(&outboxMsg{msg: []byte("live"), timeStamp: now})
+	o.Append(&outboxMsg{msg: []byte("expired"), timeStamp: now + 1, expires: now - int64(time.Second)})
+	o.Append(&outboxMsg{msg: []byte("later"), timeStamp: now + 2, expires: now + int64(time.Hour)})
+
+	msgs, _ := o.MsgsSince(0, 0)
+	if len(msgs) != 2 || string(msgs[0]) != "live" || string(msgs[1]) != "later" {
+		t.Fatalf("pickup returned an expired message: %q", msgs)
+	}
+	o.Flush(3600)
+	if len(o.outbox) != 2 {
+		t.Fatal("flush kept an expired message, outbox has", len(o.outbox))
+	}
+	o.Flush(0)
+	if len(o.outbox) != 0 {
+		t.Fatal("flush kept messages older than the max age, outbox has", len(o.outbox))
+	}
+}
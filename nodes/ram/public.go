@@ -15,6 +15,19 @@ func (node *Node) ID() (bc.PubKey, error) {
 	return node.routingKey.GetPubKey(), nil
 }
 
+// GetPeerList : Return the peers this node shares, signed with the routing key if it can sign
+func (node *Node) GetPeerList() (*api.PeerList, error) {
+	peers, err := node.GetPeers(api.SharedPeerGroup)
+	if err != nil {
+		return nil, err
+	}
+	list := api.NewPeerList(node.routingKey.GetPubKey(), peers)
+	if err := list.Sign(node.routingKey); err != nil && err != api.ErrCannotSign {
+		return nil, err
+	}
+	return list, nil
+}
+
 // Dropoff : Deliver a batch of  messages to a remote node
 func (node *Node) Dropoff(bundle api.Bundle) error {
 	events.Debug(node, "Dropoff called")
@@ -1,10 +1,13 @@
 package ram
 
 import (
+	"sync"
+
 	"github.com/awgh/bencrypt/ecc"
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/receipts"
 	"github.com/awgh/ratnet/nodes"
 	"github.com/awgh/ratnet/router"
 )
@@ -16,8 +19,9 @@ type Node struct {
 	contentKey bc.KeyPair
 	routingKey bc.KeyPair
 
-	policies []api.Policy
-	router   api.Router
+	policies  []api.Policy
+	policyMtx sync.Mutex
+	router    api.Router
 	//firstRun  bool
 	isRunning bool
 
@@ -28,6 +32,8 @@ type Node struct {
 	out    chan api.Msg
 	events chan api.Event
 
+	receipts *receipts.Tracker
+
 	// db -> ram replacements
 	channels map[string]*api.ChannelPriv
 	config   map[string]string
@@ -37,6 +43,11 @@ type Node struct {
 	profiles map[string]*api.ProfilePriv
 	streams  map[uint32]*api.StreamHeader
 	chunks   map[uint32]map[uint32]*api.Chunk
+	// streamMtx - guards streams and chunks, they are filled by Handle and read by reassembly and QueueStats
+	streamMtx sync.Mutex
+
+	peerStats    map[string]*api.PeerStats
+	peerStatsMtx sync.Mutex
 }
 
 // New : creates a new instance of API
@@ -49,6 +60,7 @@ func New(contentKey, routingKey bc.KeyPair) *Node {
 	node.config = make(map[string]string)
 	node.contacts = make(map[string]*api.Contact)
 	node.peers = make(map[string]*api.Peer)
+	node.peerStats = make(map[string]*api.PeerStats)
 	node.profiles = make(map[string]*api.ProfilePriv)
 	node.streams = make(map[uint32]*api.StreamHeader)
 	node.chunks = make(map[uint32]map[uint32]*api.Chunk)
@@ -76,6 +88,9 @@ func New(contentKey, routingKey bc.KeyPair) *Node {
 
 	// setup default router
 	node.router = router.NewDefaultRouter()
+
+	// track delivery receipts
+	node.receipts = receipts.NewTracker(node)
 	node.outbox.node = node
 
 	return node
@@ -83,12 +98,41 @@ func New(contentKey, routingKey bc.KeyPair) *Node {
 
 // GetPolicies : returns the array of Policy objects for this Node
 func (node *Node) GetPolicies() []api.Policy {
-	return node.policies
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	return append([]api.Policy(nil), node.policies...)
 }
 
-// SetPolicy : set the array of Policy objects for this Node
+// SetPolicy : set the array of Policy objects for this Node, stopping the old ones and starting the new ones if it is running
 func (node *Node) SetPolicy(policies ...api.Policy) {
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	if node.isRunning {
+		nodes.SwapPolicies(node, node.policies, policies)
+	}
+	node.policies = policies
+}
+
+// AddPolicy : Adds a Policy to this Node, starting it if the Node is running
+func (node *Node) AddPolicy(policy api.Policy) error {
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	if node.isRunning {
+		if err := policy.RunPolicy(); err != nil {
+			return err
+		}
+	}
+	node.policies = append(node.policies, policy)
+	return nil
+}
+
+// RemovePolicy : Stops a Policy of this Node and removes it
+func (node *Node) RemovePolicy(policy api.Policy) error {
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	policies, err := nodes.RemovePolicy(node.policies, policy)
 	node.policies = policies
+	return err
 }
 
 // Router : get the Router object for this Node
@@ -101,11 +145,44 @@ func (node *Node) SetRouter(router api.Router) {
 	node.router = router
 }
 
+// GetReceipt : Returns the delivery status of a message sent with the given ReceiptID, nil if unknown
+func (node *Node) GetReceipt(id []byte) (*api.Receipt, error) {
+	return node.receipts.GetReceipt(id)
+}
+
+// SignRouting : Signs data with the routing key, ErrCannotSign if its key type cannot sign
+func (node *Node) SignRouting(data []byte) ([]byte, error) {
+	return api.Sign(node.routingKey, data)
+}
+
+// SetPeerStats : Replaces the poll statistics kept for stats.Host
+func (node *Node) SetPeerStats(stats api.PeerStats) error {
+	node.peerStatsMtx.Lock()
+	defer node.peerStatsMtx.Unlock()
+	node.peerStats[stats.Host] = &stats
+	return nil
+}
+
 // FlushOutbox : Deletes outbound messages older than maxAgeSeconds seconds
 func (node *Node) FlushOutbox(maxAgeSeconds int64) {
 	node.outbox.Flush(maxAgeSeconds)
 }
 
+// QueueStats : Returns the current outbox and stream reassembly backlog
+func (node *Node) QueueStats() (api.QueueStats, error) {
+	var stats api.QueueStats
+	stats.OutboxMsgs, stats.OutboxBytes = node.outbox.Size()
+	node.streamMtx.Lock()
+	for id, stream := range node.streams {
+		if stream != nil {
+			stats.Streams++
+			stats.Chunks += len(node.chunks[id])
+		}
+	}
+	node.streamMtx.Unlock()
+	return stats, nil
+}
+
 // Channels
 
 // In : Returns the In channel of this node
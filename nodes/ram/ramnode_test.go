@@ -8,6 +8,7 @@ import (
 
 	"github.com/awgh/bencrypt/ecc"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/internal/keytest"
 )
 
 var (
@@ -78,6 +79,221 @@ func Test_apicall_Channels_1(t *testing.T) {
 	t.Log(message)
 }
 
+func Test_receipts_1(t *testing.T) {
+	sender := New(nil, nil)
+	recipient := New(nil, nil)
+	senderID, _ := sender.ID()
+	recipientID, _ := recipient.ID()
+	recipientCID, _ := recipient.CID()
+
+	id := bytes.Repeat([]byte{7}, 32)
+	if err := sender.SendMsg(api.Msg{Content: bytes.NewBufferString(testMessage1), PubKey: recipientCID, ReceiptID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := sender.GetReceipt(id); r == nil || r.Status != api.ReceiptPending {
+		t.Fatalf("receipt should be pending: %+v", r)
+	}
+
+	// deliver the message, then the receipt
+	bundle, err := sender.Pickup(recipientID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recipient.Dropoff(bundle); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-recipient.Out():
+		if msg.Content.String() != testMessage1 {
+			t.Fatal("receipt header was not removed from the delivered message:", msg.Content.String())
+		}
+	default:
+		t.Fatal("message was not delivered")
+	}
+	bundle, err = recipient.Pickup(senderID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Dropoff(bundle); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := sender.GetReceipt(id); r == nil || r.Status != api.ReceiptDelivered || r.Delivered == 0 {
+		t.Fatalf("receipt should be delivered: %+v", r)
+	}
+	select {
+	case msg := <-sender.Out():
+		t.Fatal("receipt was delivered to the application:", msg)
+	default:
+	}
+	if err := sender.SendMsg(api.Msg{Name: "chan", IsChan: true, Content: bytes.NewBufferString(testMessage1), PubKey: recipientCID, ReceiptID: id}); err == nil {
+		t.Fatal("receipt requested for a channel message")
+	}
+
+	// a direct message without a request carries the same flag, and arrives unchanged
+	plain := testMessage1[:64]
+	if err := sender.SendMsg(api.Msg{Content: bytes.NewBufferString(plain), PubKey: recipientCID}); err != nil {
+		t.Fatal(err)
+	}
+	if bundle, err = sender.Pickup(recipientID, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := recipient.Dropoff(bundle); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-recipient.Out():
+		if msg.Content.String() != plain {
+			t.Fatal("plain direct message was changed:", msg.Content.String())
+		}
+	default:
+		t.Fatal("plain direct message was not delivered")
+	}
+}
+
+func Test_apicall_PeerStats_1(t *testing.T) {
+	node.SetPeerStats(api.PeerStats{Host: "https://b:20001", Polls: 2})
+	node.SetPeerStats(api.PeerStats{Host: "https://a:20001", Failures: 1, LastError: "refused"})
+
+	result, err := node.AdminRPC(nil, api.RemoteCall{Action: "GetPeerStats"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, ok := result.([]api.PeerStats)
+	if !ok || len(stats) != 2 || stats[0].Host != "https://a:20001" || stats[1].Polls != 2 {
+		t.Fatalf("unexpected GetPeerStats result: %+v", result)
+	}
+
+	if _, err := node.AdminRPC(nil, api.RemoteCall{Action: "ResetPeerStats", Args: []interface{}{"https://a:20001"}}); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := node.GetPeerStats("https://a:20001", "https://b:20001"); len(stats) != 1 || stats[0].Host != "https://b:20001" {
+		t.Fatalf("ResetPeerStats removed the wrong hosts: %+v", stats)
+	}
+	node.ResetPeerStats()
+	if stats, _ := node.GetPeerStats(); len(stats) != 0 {
+		t.Fatal("ResetPeerStats with no hosts should clear everything")
+	}
+}
+
+func Test_apicall_GetPeerList_1(t *testing.T) {
+	node.AddPeer("shared1", true, "https://s:20001", api.SharedPeerGroup)
+	node.AddPeer("shared2", false, "s2:20001", api.SharedPeerGroup)
+	node.AddPeer("private1", true, "p:20001")
+	defer func() {
+		node.DeletePeer("shared1")
+		node.DeletePeer("shared2")
+		node.DeletePeer("private1")
+	}()
+
+	result, err := node.PublicRPC(nil, api.RemoteCall{Action: "GetPeerList"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, ok := result.(*api.PeerList)
+	if !ok || len(list.Peers) != 1 {
+		t.Fatalf("unexpected GetPeerList result: %+v", result)
+	}
+	if ad := list.Peers[0]; ad.Name != "shared1" || ad.URI != "s:20001" || ad.Transport != "https" {
+		t.Fatalf("unexpected peer in list: %+v", ad)
+	}
+}
+
+func Test_apicall_GetPeerList_Signed_1(t *testing.T) {
+	signer := New(nil, keytest.New())
+	signer.AddPeer("shared1", true, "https://s:20001", api.SharedPeerGroup)
+	result, err := signer.PublicRPC(nil, api.RemoteCall{Action: "GetPeerList"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := result.(*api.PeerList)
+	id, _ := signer.ID()
+	if err := list.Verify(id); err != nil {
+		t.Fatal("peer list signed with a key that can sign does not verify: ", err)
+	}
+
+	other, _ := New(nil, keytest.New()).ID()
+	if err := list.Verify(other); err == nil {
+		t.Fatal("peer list verified with another key")
+	}
+	list.Peers[0].URI = "attacker:20001"
+	if err := list.Verify(id); err == nil {
+		t.Fatal("altered peer list verified")
+	}
+}
+
+// testPolicy - a policy that only keeps track of whether it runs
+type testPolicy struct{ running bool }
+
+func (p *testPolicy) RunPolicy() error             { p.running = true; return nil }
+func (p *testPolicy) Stop()                        { p.running = false }
+func (p *testPolicy) MarshalJSON() ([]byte, error) { return []byte("{}"), nil }
+func (p *testPolicy) GetTransport() api.Transport  { return nil }
+func (p *testPolicy) Status() api.PolicyStatus {
+	if p.running {
+		return api.PolicyStatus{Policy: "test", State: api.StateRunning}
+	}
+	return api.PolicyStatus{Policy: "test", State: api.StateStopped}
+}
+
+func Test_apicall_Policies_1(t *testing.T) {
+	a, b := new(testPolicy), new(testPolicy)
+	if err := node.AddPolicy(a); err != nil || !a.running {
+		t.Fatalf("policy not started when added to a running node: %v", err)
+	}
+	node.SetPolicy(b)
+	if a.running || !b.running {
+		t.Fatal("SetPolicy did not swap the running policies")
+	}
+	if err := node.AddPolicy(a); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := node.AdminRPC(nil, api.RemoteCall{Action: "GetPolicyStatus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, ok := result.([]api.PolicyStatus); !ok || len(status) != 2 || status[1].State != api.StateRunning {
+		t.Fatalf("unexpected GetPolicyStatus result: %+v", result)
+	}
+	if _, err := node.AdminRPC(nil, api.RemoteCall{Action: "RemovePolicy", Args: []interface{}{int64(0)}}); err != nil {
+		t.Fatal(err)
+	}
+	if b.running || len(node.GetPolicies()) != 1 {
+		t.Fatal("RemovePolicy did not stop and remove the policy")
+	}
+	if _, err := node.AdminRPC(nil, api.RemoteCall{Action: "RemovePolicy", Args: []interface{}{int64(1)}}); err == nil {
+		t.Fatal("RemovePolicy accepted an index out of range")
+	}
+	if _, err := node.AdminRPC(nil, api.RemoteCall{Action: "AddPolicy",
+		Args: []interface{}{`{"Policy":"none","Transport":{"Transport":"none"}}`}}); err == nil {
+		t.Fatal("AddPolicy accepted an unknown type")
+	}
+	if err := node.RemovePolicy(a); err != nil || a.running {
+		t.Fatalf("policy not stopped when removed: %v", err)
+	}
+}
+
+func Test_apicall_Pickup_TooBig_1(t *testing.T) {
+	node.FlushOutbox(0)
+	big := api.Msg{Name: "big", IsChan: true, Content: bytes.NewBuffer(bytes.Repeat([]byte{1}, 1024))}
+	if err := node.Forward(big); err != nil {
+		t.Fatal(err)
+	}
+	rpk, err := node.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the only message can never fit, the cursor has to move past it or the peer is stuck
+	bundle, err := node.Pickup(rpk, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Data) != 0 || bundle.Time == 0 {
+		t.Fatalf("oversized message was not skipped: %d bytes, cursor %d", len(bundle.Data), bundle.Time)
+	}
+	node.FlushOutbox(0)
+}
+
 func Test_stop(t *testing.T) {
 	node.Stop()
 }
@@ -1,10 +1,12 @@
 package nodes
 
 import (
+	"encoding/json"
 	"errors"
 	"strconv"
 
 	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/ratnet"
 	"github.com/awgh/ratnet/api"
 )
 
@@ -58,6 +60,9 @@ func PublicRPC(transport api.Transport, node api.Node, call api.RemoteCall) (int
 		}
 		return nil, node.Dropoff(bundle)
 
+	case "GetPeerList":
+		return node.GetPeerList()
+
 	default:
 		return nil, errors.New("No such method: " + call.Action)
 	}
@@ -345,7 +350,84 @@ func AdminRPC(transport api.Transport, node api.Node, call api.RemoteCall) (inte
 		}
 		return nil, node.SendChannel(channelName, msg)
 
+	case "GetPeerStats":
+		hosts, err := stringArgs(call.Args)
+		if err != nil {
+			return nil, err
+		}
+		return node.GetPeerStats(hosts...)
+
+	case "ResetPeerStats":
+		hosts, err := stringArgs(call.Args)
+		if err != nil {
+			return nil, err
+		}
+		return nil, node.ResetPeerStats(hosts...)
+
+	case "GetPolicyStatus":
+		return PolicyStatus(node.GetPolicies()), nil
+
+	case "AddPolicy":
+		if len(call.Args) < 1 {
+			return nil, errors.New("Invalid argument count")
+		}
+		config, ok := call.Args[0].(string)
+		if !ok {
+			return nil, errors.New("Invalid argument")
+		}
+		policy, err := policyFromJSON(node, config)
+		if err != nil {
+			return nil, err
+		}
+		return nil, node.AddPolicy(policy)
+
+	case "RemovePolicy":
+		if len(call.Args) < 1 {
+			return nil, errors.New("Invalid argument count")
+		}
+		i, ok := call.Args[0].(int64)
+		if !ok {
+			return nil, errors.New("Invalid argument")
+		}
+		policies := node.GetPolicies()
+		if i < 0 || i >= int64(len(policies)) {
+			return nil, errors.New("No policy at index " + strconv.FormatInt(i, 10))
+		}
+		return nil, node.RemovePolicy(policies[i])
+
 	default:
 		return node.PublicRPC(transport, call)
 	}
 }
+
+// stringArgs - returns the arguments of a call that takes any number of strings
+func stringArgs(args []interface{}) ([]string, error) {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return nil, errors.New("Invalid argument")
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+// policyFromJSON - makes a Policy and its Transport from a policy config, as found in the Policies of a node config
+func policyFromJSON(node api.Node, config string) (api.Policy, error) {
+	var p map[string]interface{}
+	if err := json.Unmarshal([]byte(config), &p); err != nil {
+		return nil, err
+	}
+	t, ok := p["Transport"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Transport missing from policy config")
+	}
+	if name, _ := t["Transport"].(string); ratnet.Transports[name] == nil {
+		return nil, errors.New("Unknown Transport type: " + name)
+	}
+	if name, _ := p["Policy"].(string); ratnet.Policies[name] == nil {
+		return nil, errors.New("Unknown Policy type: " + name)
+	}
+	return ratnet.NewPolicyFromMap(ratnet.NewTransportFromMap(node, t), node, p), nil
+}
@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Defaults for the settings of a Poll policy, in milliseconds except for concurrency,
+// backoff and the circuit breaker are off unless BackoffBase or FailureThreshold are set
+const (
+	DefaultBackoffMax  = 5 * 60 * 1000
+	DefaultCooldown    = 10 * 60 * 1000
+	DefaultConcurrency = 4
+	DefaultPeerTimeout = 60 * 1000
+	DefaultSessionTime = 30 * 1000
+)
+
+// backoff - tracks failures per peer host, delaying the next attempt exponentially (with jitter)
+// and, once a peer has failed threshold times in a row, not trying it again until the cooldown is over
+type backoff struct {
+	base      time.Duration // delay after the first failure, 0 to retry on every round
+	max       time.Duration // 0 or less for no limit but the largest time.Duration
+	threshold int           // consecutive failures that open the circuit, 0 to never open it
+	cooldown  time.Duration
+
+	mtx   sync.Mutex
+	peers map[string]*backoffState
+}
+
+type backoffState struct {
+	failures int
+	next     time.Time // no attempts before this
+	open     bool      // the circuit is open, the peer is skipped until next
+}
+
+func newBackoff(base, max time.Duration, threshold int, cooldown time.Duration) *backoff {
+	b := new(backoff)
+	b.base = base
+	b.max = max
+	b.threshold = threshold
+	b.cooldown = cooldown
+	b.peers = make(map[string]*backoffState)
+	return b
+}
+
+// ready - returns whether the host may be polled now
+func (b *backoff) ready(host string, now time.Time) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	s, ok := b.peers[host]
+	return !ok || !now.Before(s.next)
+}
+
+// success - resets the host, returns whether its circuit had been open
+func (b *backoff) success(host string) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	s, ok := b.peers[host]
+	delete(b.peers, host)
+	return ok && s.open
+}
+
+// failure - schedules the next attempt for the host, returns whether this failure opened its circuit
+func (b *backoff) failure(host string, now time.Time) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	s, ok := b.peers[host]
+	if !ok {
+		s = new(backoffState)
+		b.peers[host] = s
+	}
+	s.failures++
+
+	if b.threshold > 0 && s.failures >= b.threshold {
+		opened := !s.open
+		s.open = true
+		s.next = now.Add(b.cooldown)
+		return opened
+	}
+	if b.base > 0 {
+		delay := b.base
+		// stop doubling before it overflows, when there is no max to stop it first
+		for i := 1; i < s.failures && delay <= math.MaxInt64/2 && (b.max <= 0 || delay < b.max); i++ {
+			delay *= 2
+		}
+		if b.max > 0 && delay > b.max {
+			delay = b.max
+		}
+		// wait between half and all of the delay, so peers that failed together are not retried together
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		s.next = now.Add(delay)
+	}
+	return false
+}
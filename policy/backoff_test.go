@@ -0,0 +1,86 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Backoff_Steps_1(t *testing.T) {
+	b := newBackoff(100*time.Millisecond, time.Second, 0, 0)
+	now := time.Now()
+	// doubled after each failure up to the limit, with the jitter taking off up to half
+	for i, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		if b.failure("a", now) {
+			t.Fatal("circuit opened without a threshold")
+		}
+		delay := b.peers["a"].next.Sub(now)
+		if delay < max/2 || delay > max {
+			t.Fatalf("failure %d: delay %v outside [%v, %v]", i+1, delay, max/2, max)
+		}
+		if b.ready("a", now) || !b.ready("a", now.Add(max)) {
+			t.Fatalf("failure %d: ready does not follow the delay", i+1)
+		}
+	}
+	if b.success("a") || !b.ready("a", now) {
+		t.Fatal("success did not reset the peer")
+	}
+	if !b.ready("b", now) {
+		t.Fatal("a peer that never failed is not ready")
+	}
+}
+
+func Test_Backoff_NoMax_1(t *testing.T) {
+	b := newBackoff(5*time.Second, 0, 0, 0)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		b.failure("a", now)
+		if delay := b.peers["a"].next.Sub(now); delay < b.base/2 {
+			t.Fatalf("failure %d: delay %v overflowed", i+1, delay)
+		}
+	}
+}
+
+func Test_Backoff_Off_1(t *testing.T) {
+	b := newBackoff(0, time.Second, 0, time.Minute)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if b.failure("a", now) || !b.ready("a", now) {
+			t.Fatal("a failing peer was held back with backoff and breaker off")
+		}
+	}
+}
+
+func Test_Backoff_Breaker_1(t *testing.T) {
+	b := newBackoff(0, 0, 3, time.Minute)
+	now := time.Now()
+	if b.failure("a", now) || b.failure("a", now) {
+		t.Fatal("circuit opened before the threshold")
+	}
+	if !b.failure("a", now) {
+		t.Fatal("circuit did not open at the threshold")
+	}
+	if b.ready("a", now.Add(time.Minute-time.Second)) {
+		t.Fatal("open circuit let a poll through before the cooldown")
+	}
+
+	// half-open: one trial poll after the cooldown, a failure opens it again for another cooldown
+	later := now.Add(time.Minute)
+	if !b.ready("a", later) {
+		t.Fatal("circuit did not half-open after the cooldown")
+	}
+	if b.failure("a", later) {
+		t.Fatal("a failed trial reported the circuit as newly opened")
+	}
+	if b.ready("a", later.Add(time.Minute-time.Second)) || !b.ready("a", later.Add(time.Minute)) {
+		t.Fatal("a failed trial did not start another cooldown")
+	}
+
+	// a successful trial closes it and resets the count
+	if !b.success("a") {
+		t.Fatal("success did not report the circuit as closed")
+	}
+	if b.failure("a", later) || b.failure("a", later) || !b.ready("a", later) {
+		t.Fatal("failure count was not reset")
+	}
+}
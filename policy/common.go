@@ -1,41 +1,210 @@
 package policy
 
 import (
-	"sync"
+	"errors"
+	"time"
 
 	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/events"
 )
 
-var peerTable map[string]*api.PeerInfo
-var lock = sync.RWMutex{}
+// checkSigning - returns an error unless the node can sign with its routing key and check signatures by keys of its type,
+// for policies that are configured to require signatures
+func checkSigning(node api.Node) error {
+	signer, ok := node.(api.RoutingSigner)
+	if !ok {
+		return api.ErrCannotSign
+	}
+	if _, err := signer.SignRouting(nil); err != nil {
+		return err
+	}
+	id, err := node.ID()
+	if err != nil {
+		return err
+	}
+	_, err = api.Verify(id, nil, nil)
+	return err
+}
+
+// PollMode - which directions PollServerWith moves messages in
+type PollMode int
+
+// Poll modes, PollBoth is the zero value
+const (
+	PollBoth PollMode = iota // send local messages to the peer and fetch the peer's messages
+	PollPush                 // only send local messages to the peer
+	PollPull                 // only fetch the peer's messages
+)
+
+var pollModeNames = []string{"both", "push", "pull"}
 
-func readPeerTable(key string) (*api.PeerInfo, bool) {
-	lock.RLock()
-	defer lock.RUnlock()
-	v, ok := peerTable[key]
-	return v, ok
+// String : Returns the name of the mode used in JSON configs
+func (m PollMode) String() string {
+	if m < 0 || int(m) >= len(pollModeNames) {
+		return "unknown"
+	}
+	return pollModeNames[m]
+}
+
+// ParsePollMode : Returns the mode with the given name
+func ParsePollMode(name string) (PollMode, error) {
+	for i, n := range pollModeNames {
+		if n == name {
+			return PollMode(i), nil
+		}
+	}
+	return PollBoth, errors.New("Unknown poll mode: " + name)
 }
-func writePeerTable(key string, val *api.PeerInfo) {
-	lock.Lock()
-	defer lock.Unlock()
-	peerTable[key] = val
+
+// policyStatus - builds the Status of a policy from whether it runs and the error that stopped it, if any
+func policyStatus(name string, running bool, err error) api.PolicyStatus {
+	if err != nil {
+		return api.PolicyStatus{Policy: name, State: api.StateError, Err: err.Error()}
+	}
+	if running {
+		return api.PolicyStatus{Policy: name, State: api.StateRunning}
+	}
+	return api.PolicyStatus{Policy: name, State: api.StateStopped}
 }
 
-func init() {
-	peerTable = make(map[string]*api.PeerInfo)
+// PollOptions - how PollServerWith exchanges messages with a peer, the zero value does a single exchange both ways
+type PollOptions struct {
+	Mode PollMode
+	// Drain - keep exchanging bundles until neither side has anything newer than the cursors,
+	// for when a backlog does not fit in one bundle of the transport's ByteLimit
+	Drain bool
+	// MaxBytes - stop draining once this many bytes have moved in the session, 0 for no limit
+	MaxBytes int64
+	// MaxTime - stop draining once the session has lasted this long, 0 for no limit
+	MaxTime time.Duration
+	// Deadline - stop draining once this time has passed, the zero value for none
+	Deadline time.Time
+	// Channels - only fetch messages for these channels from the peer ("" for direct messages), all of them if empty
+	Channels []string
 }
 
+// sharedPeers - the state of the hosts polled through PollServer, which callers do not keep themselves
+var sharedPeers = NewPeerTable(false)
+
 // PollServer does a Push/Pull between a local and remote Node
 func PollServer(transport api.Transport, node api.Node, host string, pubsrv bc.PubKey) (bool, error) {
+	return PollServerWith(transport, node, sharedPeers, host, pubsrv, PollOptions{})
+}
+
+// PollServerWith : Does the exchanges of PollServer as opts says, keeping the state of the host in peers,
+// and reports the outcome as a PeerPolled or PeerFailed event
+func PollServerWith(transport api.Transport, node api.Node, peers *PeerTable, host string, pubsrv bc.PubKey, opts PollOptions) (bool, error) {
+	report := newPollReport(peers, host)
+	result, err := pollServer(transport, node, peers, host, pubsrv, opts)
+	peer, _ := peers.lookup(host)
+	report.send(node, peer, err)
+	return result, err
+}
+
+// pollReport - the state of a peer when a poll started, to report what the poll changed once it ends
+type pollReport struct {
+	host   string
+	start  time.Time
+	tx, rx int64
+}
+
+func newPollReport(peers *PeerTable, host string) pollReport {
+	r := pollReport{host: host, start: time.Now()}
+	if peer, ok := peers.lookup(host); ok {
+		r.tx, r.rx = peer.TotalBytesTX, peer.TotalBytesRX
+	}
+	return r
+}
+
+// send - adds the outcome of the poll to the peer statistics and reports it as a PeerPolled or PeerFailed event,
+// peer is nil when the poll is reported before it has finished
+func (r pollReport) send(node api.Node, peer *api.PeerInfo, err error) {
+	e := api.PeerEvent{Host: r.host, Err: err, Latency: time.Since(r.start)}
+	if peer != nil {
+		e.BytesTX, e.BytesRX = peer.TotalBytesTX-r.tx, peer.TotalBytesRX-r.rx
+	}
+	if err := recordPoll(node, peer, e); err != nil {
+		events.Warning(node, "could not save peer stats: "+err.Error())
+	}
+	if err != nil {
+		events.Emit(node, api.Warning, api.PeerFailed, e)
+	} else {
+		events.Emit(node, api.Debug, api.PeerPolled, e)
+	}
+}
+
+// recordPoll - adds the outcome of a poll to the peer statistics kept by the node
+func recordPoll(node api.Node, peer *api.PeerInfo, e api.PeerEvent) error {
+	stats := api.PeerStats{Host: e.Host}
+	if s, err := node.GetPeerStats(e.Host); err != nil {
+		return err
+	} else if len(s) > 0 {
+		stats = s[0]
+	}
+	if peer != nil {
+		stats.LastPollLocal, stats.LastPollRemote = peer.LastPollLocal, peer.LastPollRemote
+	}
+	stats.TotalBytesTX += e.BytesTX
+	stats.TotalBytesRX += e.BytesRX
+	if e.Err != nil {
+		stats.Failures++
+		stats.LastFailure = time.Now().UnixNano()
+		stats.LastError = e.Err.Error()
+	} else {
+		stats.Polls++
+		stats.LastSuccess = time.Now().UnixNano()
+	}
+	return node.SetPeerStats(stats)
+}
+
+// pollServer - runs exchanges with the host until it is drained, or just one if opts.Drain is not set
+func pollServer(transport api.Transport, node api.Node, peers *PeerTable, host string, pubsrv bc.PubKey, opts PollOptions) (bool, error) {
 	// make PeerInfo for this host if doesn't exist
-	if _, ok := readPeerTable(host); !ok {
-		writePeerTable(host, new(api.PeerInfo))
+	peer := peers.Get(node, host)
+	start := time.Now()
+	var moved int64
+	stalled := 0
+	for {
+		local, remote := peer.LastPollLocal, peer.LastPollRemote
+		before := peer.TotalBytesTX + peer.TotalBytesRX
+		if happy, err := pollRound(transport, node, peer, host, pubsrv, opts.Mode, opts.Channels); !happy {
+			return happy, err
+		}
+		n := peer.TotalBytesTX + peer.TotalBytesRX - before
+		moved += n
+		if !opts.Drain || n == 0 {
+			return true, nil
+		}
+		if opts.MaxBytes > 0 && moved >= opts.MaxBytes {
+			events.Debug(node, "pollServer session byte budget reached for ", host)
+			return true, nil
+		}
+		if opts.MaxTime > 0 && time.Since(start) >= opts.MaxTime {
+			events.Debug(node, "pollServer session time budget reached for ", host)
+			return true, nil
+		}
+		if !opts.Deadline.IsZero() && !time.Now().Before(opts.Deadline) {
+			events.Debug(node, "pollServer deadline reached for ", host)
+			return true, nil
+		}
+		// the local cursor only starts moving on the second exchange with a peer,
+		// so give it one round to catch up before deciding the session is stuck
+		if local == peer.LastPollLocal && remote == peer.LastPollRemote {
+			if stalled++; stalled > 1 {
+				return true, nil
+			}
+		} else {
+			stalled = 0
+		}
 	}
-	peer, _ := readPeerTable(host)
+}
 
-	if peer.RoutingPub == nil {
+// pollRound - exchanges one bundle each way with the host
+func pollRound(transport api.Transport, node api.Node, peer *api.PeerInfo, host string, pubsrv bc.PubKey, mode PollMode, channels []string) (bool, error) {
+	push, pull := mode != PollPull, mode != PollPush
+
+	if push && peer.RoutingPub == nil { // only needed to pick up local messages for the peer
 		rpubkey, err := transport.RPC(host, "ID")
 		if err != nil {
 			events.Error(node, err.Error())
@@ -50,19 +219,32 @@ func PollServer(transport api.Transport, node api.Node, host string, pubsrv bc.P
 	}
 
 	// Pickup Local
-	toRemote, err := node.Pickup(peer.RoutingPub, peer.LastPollLocal, transport.ByteLimit())
-	if err != nil {
-		events.Error(node, "local pickup error: "+err.Error())
-		return false, err
+	var toRemote api.Bundle
+	if push {
+		var err error
+		toRemote, err = node.Pickup(peer.RoutingPub, peer.LastPollLocal, transport.ByteLimit())
+		if err != nil {
+			events.Error(node, "local pickup error: "+err.Error())
+			return false, err
+		}
+		events.Debug(node, "pollServer Pickup Local result len: ", len(toRemote.Data))
 	}
-	events.Debug(node, "pollServer Pickup Local result len: ", len(toRemote.Data))
 
 	// Pickup Remote
-	toLocalRaw, err := transport.RPC(host, "Pickup", pubsrv, peer.LastPollRemote)
-	if err != nil {
-		events.Error(node, "remote pickup error: "+err.Error())
-		return false, err
+	var toLocalRaw interface{}
+	if pull {
+		var err error
+		args := []interface{}{pubsrv, peer.LastPollRemote}
+		for _, c := range channels {
+			args = append(args, c)
+		}
+		toLocalRaw, err = transport.RPC(host, "Pickup", args...)
+		if err != nil {
+			events.Error(node, "remote pickup error: "+err.Error())
+			return false, err
+		}
 	}
+	var err error
 	var toLocal api.Bundle
 	var ok bool
 	if toLocalRaw != nil {
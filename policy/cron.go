@@ -0,0 +1,195 @@
+package policy
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec - a five field cron expression (minute hour day-of-month month day-of-week),
+// each field a comma separated list of *, N or N-M, optionally followed by /STEP.
+// As in cron, a day field starting with * (such as */2) leaves the other day field alone to restrict the day
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64 // bit i set when value i matches
+	domAny, dowAny                bool
+}
+
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parseCron - parses a cron expression, 7 is accepted as Sunday in the day-of-week field
+func parseCron(expr string) (*cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("Cron expression needs 5 fields: " + expr)
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := parseCronField(field, cronBounds[i][0], cronBounds[i][1])
+		if err != nil {
+			return nil, errors.New("Bad cron field " + field + ": " + err.Error())
+		}
+		bits[i] = b
+	}
+	c := &cronSpec{minute: bits[0], hour: bits[1], dom: bits[2], month: bits[3], dow: bits[4]}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, errors.New("bad step")
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = n, n
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = max // N/STEP runs from N to the end of the range
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, errors.New("out of range")
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// match - returns whether the minute of t matches the expression
+func (c *cronSpec) match(t time.Time) bool {
+	return c.minute&(1<<uint(t.Minute())) != 0 && c.hour&(1<<uint(t.Hour())) != 0 &&
+		c.month&(1<<uint(t.Month())) != 0 && c.matchDay(t)
+}
+
+// last - returns the latest minute at or before t that matches the expression, false if there is none after earliest,
+// skipping whole months, days and hours that do not match rather than going back minute by minute
+func (c *cronSpec) last(t, earliest time.Time) (time.Time, bool) {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	for t.After(earliest) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()).Add(-time.Minute)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(-time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// matchDay - returns whether the day of t matches the day-of-month and day-of-week fields
+func (c *cronSpec) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	// as in cron, when both day fields are restricted either one matching is enough
+	if !c.domAny && !c.dowAny {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// dailyWindow - a time of day range, on some days of the week
+type dailyWindow struct {
+	days       [7]bool
+	start, end time.Duration // since midnight, end before start for windows that run past midnight, equal for a whole day
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseDailyWindow - parses "HH:MM-HH:MM" for every day, or with days first as in "Mon-Fri 08:00-17:00" or "Sat,Sun 10:00-11:00",
+// a window ending at the time it starts lasts 24 hours
+func parseDailyWindow(s string) (*dailyWindow, error) {
+	w := new(dailyWindow)
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, errors.New("Bad window: " + s)
+	}
+	if len(fields) == 1 {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	} else {
+		for _, part := range strings.Split(fields[0], ",") {
+			bounds := strings.SplitN(strings.ToLower(part), "-", 2)
+			first, ok := weekdays[bounds[0]]
+			last := first
+			if ok && len(bounds) == 2 {
+				last, ok = weekdays[bounds[1]]
+			}
+			if !ok {
+				return nil, errors.New("Bad days in window: " + s)
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+	}
+	times := strings.SplitN(fields[len(fields)-1], "-", 2)
+	if len(times) != 2 {
+		return nil, errors.New("Bad times in window: " + s)
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(times[0]); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseTimeOfDay(times[1]); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// closes - returns when the window t falls in closes, false if t is outside it
+func (w *dailyWindow) closes(t time.Time) (time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	since := t.Sub(midnight)
+	day := t.Weekday()
+	if w.start < w.end {
+		return midnight.Add(w.end), w.days[day] && since >= w.start && since < w.end
+	}
+	// runs past midnight: the evening of a listed day, or the morning after one
+	if w.days[day] && since >= w.start {
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()).Add(w.end), true
+	}
+	return midnight.Add(w.end), w.days[(day+6)%7] && since < w.end
+}
@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+// at - a time in the week of Monday 2024-01-01, day 0 being that Monday
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 1, 1+day, hour, minute, 0, 0, time.UTC)
+}
+
+func Test_ParseCron_1(t *testing.T) {
+	for _, c := range []struct {
+		expr      string
+		match, no []time.Time
+	}{
+		{"*/15 * * * *", []time.Time{at(0, 0, 0), at(0, 0, 15), at(3, 7, 45)}, []time.Time{at(0, 0, 10), at(0, 0, 59)}},
+		{"5-20/5 * * * *", []time.Time{at(0, 1, 5), at(0, 1, 10), at(0, 1, 20)}, []time.Time{at(0, 1, 0), at(0, 1, 25), at(0, 1, 7)}},
+		{"10/20 * * * *", []time.Time{at(0, 1, 10), at(0, 1, 30), at(0, 1, 50)}, []time.Time{at(0, 1, 0), at(0, 1, 20)}},
+		{"0 9-17 * * 1-5", []time.Time{at(0, 9, 0), at(4, 17, 0)}, []time.Time{at(5, 9, 0), at(0, 18, 0), at(0, 9, 1), at(6, 12, 0)}},
+		{"0 0 * * 7", []time.Time{at(6, 0, 0)}, []time.Time{at(5, 0, 0), at(0, 0, 0)}},
+		{"0 0 * * 0", []time.Time{at(6, 0, 0)}, []time.Time{at(5, 0, 0)}},
+		{"0,30 8,20 * * 6-7", []time.Time{at(5, 8, 30), at(6, 20, 0)}, []time.Time{at(4, 8, 30), at(5, 9, 0)}},
+		// both day fields restricted: either one matching is enough
+		{"0 0 3 * 1", []time.Time{at(0, 0, 0), at(2, 0, 0), at(7, 0, 0)}, []time.Time{at(1, 0, 0)}},
+		{"0 0 3 * *", []time.Time{at(2, 0, 0)}, []time.Time{at(0, 0, 0)}},
+		// a day field starting with * does not count as restricted: both must match
+		{"0 0 */2 * 1", []time.Time{at(0, 0, 0), at(14, 0, 0)}, []time.Time{at(7, 0, 0), at(2, 0, 0)}},
+		{"0 0 1-7 * */2", []time.Time{at(1, 0, 0), at(5, 0, 0)}, []time.Time{at(0, 0, 0), at(8, 0, 0)}},
+		{"30 2 * 3,6 *", []time.Time{time.Date(2024, 6, 10, 2, 30, 0, 0, time.UTC)}, []time.Time{at(0, 2, 30)}},
+	} {
+		spec, err := parseCron(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		for _, m := range c.match {
+			if !spec.match(m) {
+				t.Fatalf("%s does not match %v", c.expr, m)
+			}
+		}
+		for _, m := range c.no {
+			if spec.match(m) {
+				t.Fatalf("%s matches %v", c.expr, m)
+			}
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *",
+		"0 0 * 13 *", "0 0 * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "1-a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Fatalf("%q parsed", expr)
+		}
+	}
+}
+
+func Test_Cron_Last_1(t *testing.T) {
+	// the same answer as going back minute by minute
+	span := 3 * 24 * time.Hour
+	for _, expr := range []string{"*/15 * * * *", "0 9-17 * * 1-5", "0 0 * * 7", "0 0 3 * 1", "59 23 31 12 *", "0 12 1 * *"} {
+		spec, _ := parseCron(expr)
+		for _, now := range []time.Time{at(0, 0, 0), at(2, 9, 30), at(6, 23, 59), time.Date(2024, 3, 2, 0, 10, 30, 0, time.UTC)} {
+			earliest := now.Add(-span)
+			var want time.Time
+			found := false
+			for m := now.Truncate(time.Minute); m.After(earliest); m = m.Add(-time.Minute) {
+				if spec.match(m) {
+					want, found = m, true
+					break
+				}
+			}
+			got, ok := spec.last(now, earliest)
+			if ok != found || !got.Equal(want) {
+				t.Fatalf("%s at %v: last match %v %v, expected %v %v", expr, now, got, ok, want, found)
+			}
+		}
+	}
+}
+
+func Test_ParseDailyWindow_1(t *testing.T) {
+	for _, c := range []struct {
+		window       string
+		open, closed []time.Time
+	}{
+		{"08:00-17:00", []time.Time{at(0, 8, 0), at(6, 16, 59)}, []time.Time{at(0, 7, 59), at(0, 17, 0)}},
+		{"Mon-Fri 08:00-17:00", []time.Time{at(0, 8, 0), at(4, 12, 0)}, []time.Time{at(5, 12, 0), at(6, 12, 0)}},
+		// past midnight: the evening of a listed day and the morning after it
+		{"Mon-Fri 22:00-02:00", []time.Time{at(0, 23, 0), at(1, 1, 0), at(4, 22, 0), at(5, 1, 59)},
+			[]time.Time{at(0, 1, 0), at(5, 22, 0), at(6, 1, 0), at(1, 2, 0), at(1, 21, 59)}},
+		// day ranges wrap around the end of the week
+		{"Fri-Mon 10:00-11:00", []time.Time{at(4, 10, 30), at(5, 10, 30), at(6, 10, 30), at(7, 10, 30)},
+			[]time.Time{at(1, 10, 30), at(3, 10, 30)}},
+		{"Sat,Sun 10:00-11:00", []time.Time{at(5, 10, 0), at(6, 10, 59)}, []time.Time{at(4, 10, 0), at(6, 11, 0)}},
+		{"sat,mon-tue 10:00-11:00", []time.Time{at(5, 10, 0), at(0, 10, 0), at(1, 10, 0)}, []time.Time{at(2, 10, 0)}},
+		// a window ending when it starts lasts a whole day
+		{"08:00-08:00", []time.Time{at(0, 7, 59), at(0, 8, 0), at(3, 20, 0)}, nil},
+		{"Sun 00:00-00:00", []time.Time{at(6, 0, 0), at(6, 23, 59)}, []time.Time{at(5, 23, 59), at(7, 0, 0)}},
+		{"Mon 08:00-08:00", []time.Time{at(0, 8, 0), at(1, 7, 59)}, []time.Time{at(0, 7, 59), at(1, 8, 0)}},
+	} {
+		w, err := parseDailyWindow(c.window)
+		if err != nil {
+			t.Fatalf("%s: %v", c.window, err)
+		}
+		for _, tm := range c.open {
+			if _, ok := w.closes(tm); !ok {
+				t.Fatalf("%s is closed at %v", c.window, tm)
+			}
+		}
+		for _, tm := range c.closed {
+			if _, ok := w.closes(tm); ok {
+				t.Fatalf("%s is open at %v", c.window, tm)
+			}
+		}
+	}
+
+	for _, window := range []string{"", "Mon-Fri", "08:00", "Xyz 08:00-09:00", "Mon-Xyz 08:00-09:00",
+		"25:00-26:00", "08:00-9", "Mon 08:00-09:00 extra"} {
+		if _, err := parseDailyWindow(window); err == nil {
+			t.Fatalf("%q parsed", window)
+		}
+	}
+}
+
+func Test_DailyWindow_Closes_1(t *testing.T) {
+	for _, c := range []struct {
+		window string
+		t      time.Time
+		closes time.Time
+	}{
+		{"08:00-17:00", at(0, 9, 0), at(0, 17, 0)},
+		{"Mon-Fri 22:00-02:00", at(0, 23, 0), at(1, 2, 0)},
+		{"Mon-Fri 22:00-02:00", at(5, 1, 0), at(5, 2, 0)},
+		{"08:00-08:00", at(0, 9, 0), at(1, 8, 0)},
+		{"08:00-08:00", at(0, 7, 0), at(0, 8, 0)},
+	} {
+		w, _ := parseDailyWindow(c.window)
+		if closes, ok := w.closes(c.t); !ok || !closes.Equal(c.closes) {
+			t.Fatalf("%s at %v: closes %v %v, expected %v", c.window, c.t, closes, ok, c.closes)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package policy
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// discoveredPrefix - starts the names of discovered peers in the node's peer list
+const discoveredPrefix = "p2p:"
+
+// discoveredPeer - a peer found through P2P advertisements, polled until it expires or the policy stops
+type discoveredPeer struct {
+	name     string // in the node's peer list
+	host     string // as passed to PollServer
+	lastSeen time.Time
+	stop     chan struct{}
+}
+
+// seen - refreshes the last-seen time of a discovered peer, returns false if it is not known
+func (s *P2P) seen(target string) bool {
+	s.discoveredMtx.Lock()
+	defer s.discoveredMtx.Unlock()
+	peer, ok := s.discovered[target]
+	if ok {
+		peer.lastSeen = time.Now()
+	}
+	return ok
+}
+
+// discover - lists a new peer in PeerGroup and starts polling it, unless MaxPeers are already being polled
+func (s *P2P) discover(target, host string, trans api.Transport, pubsrv bc.PubKey) error {
+	s.discoveredMtx.Lock()
+	defer s.discoveredMtx.Unlock()
+	if _, ok := s.discovered[target]; ok || !s.IsListening {
+		return nil
+	}
+	if s.MaxPeers > 0 && len(s.discovered) >= s.MaxPeers {
+		if !s.full {
+			s.full = true
+			events.Warning(s.Node, "p2p is polling MaxPeers (", s.MaxPeers, ") discovered peers, ignoring new ones until one expires: ", target)
+		}
+		return errors.New("already polling the most discovered peers allowed")
+	}
+	peer := &discoveredPeer{name: discoveredPrefix + target, host: host, lastSeen: time.Now(), stop: make(chan struct{})}
+	if err := s.Node.AddPeer(peer.name, true, target, s.PeerGroup); err != nil {
+		return err
+	}
+	s.discovered[target] = peer
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			st := time.Now()
+			if happy, err := PollServerWith(trans, s.Node, s.peers, host, pubsrv, PollOptions{}); !happy {
+				if err != nil {
+					events.Warning(s.Node, err.Error())
+				}
+			}
+			st2 := time.Now()
+			events.Debug(s.Node, "p2p PollServer took: ", st2.Sub(st).String())
+			runtime.GC()
+			st3 := time.Now()
+			events.Debug(s.Node, "p2p GC took: ", st3.Sub(st2).String())
+			select {
+			case <-peer.stop:
+				return
+			case <-time.After(time.Duration(s.ListenInterval) * time.Millisecond): // update interval
+			}
+		}
+	}()
+	return nil
+}
+
+// expireLoop - drops discovered peers that have not advertised for ExpireAfter, until the policy stops
+func (s *P2P) expireLoop() {
+	defer s.wg.Done()
+	expiry := time.Duration(s.ExpireAfter) * time.Millisecond
+	ticker := time.NewTicker(expiry / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.discoveredMtx.Lock()
+			for target, peer := range s.discovered {
+				if now.Sub(peer.lastSeen) > expiry {
+					events.Info(s.Node, "p2p peer stopped advertising, no longer polling it: ", target)
+					s.forget(target, peer)
+				}
+			}
+			s.discoveredMtx.Unlock()
+		}
+	}
+}
+
+// pruneDiscovered - removes the discovered peers left in PeerGroup by a run that did not stop cleanly
+func (s *P2P) pruneDiscovered() error {
+	peers, err := s.Node.GetPeers(s.PeerGroup)
+	if err != nil {
+		return err
+	}
+	for _, p := range peers {
+		if strings.HasPrefix(p.Name, discoveredPrefix) {
+			if err := s.Node.DeletePeer(p.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// forgetAll - stops polling every discovered peer
+func (s *P2P) forgetAll() {
+	s.discoveredMtx.Lock()
+	defer s.discoveredMtx.Unlock()
+	for target, peer := range s.discovered {
+		s.forget(target, peer)
+	}
+}
+
+// forget - stops polling a discovered peer and removes it from the node's peer list, discoveredMtx must be held
+func (s *P2P) forget(target string, peer *discoveredPeer) {
+	close(peer.stop)
+	delete(s.discovered, target)
+	s.full = false
+	s.peers.Forget(peer.host)
+	if err := s.Node.DeletePeer(peer.name); err != nil {
+		events.Warning(s.Node, "p2p could not remove discovered peer: "+err.Error())
+	}
+}
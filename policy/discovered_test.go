@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestP2P - a P2P policy that discovers peers over transport, without its sockets
+func newTestP2P(transport *fakeTransport) *P2P {
+	p, _ := newTestPoll(transport, 0)
+	s := NewP2P(transport, "", p.node, false, 60000, 60000)
+	s.discovered = make(map[string]*discoveredPeer)
+	s.done = make(chan struct{})
+	s.IsListening = true
+	return s
+}
+
+// listed - whether the node lists the discovered peer in PeerGroup
+func listed(t *testing.T, s *P2P, target string) bool {
+	peers, err := s.Node.GetPeers(s.PeerGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range peers {
+		if p.URI == target {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_P2P_Discover_1(t *testing.T) {
+	transport := &fakeTransport{}
+	s := newTestP2P(transport)
+	s.ExpireAfter = 80
+	pubsrv, _ := s.Node.ID()
+	s.wg.Add(1)
+	go s.expireLoop()
+	defer func() {
+		close(s.done)
+		s.forgetAll()
+		s.wg.Wait()
+	}()
+
+	// added and polled
+	if err := s.discover("fake://a", "a", transport, pubsrv); err != nil {
+		t.Fatal(err)
+	}
+	if !listed(t, s, "fake://a") {
+		t.Fatal("discovered peer is not in the peer list")
+	}
+	for i := 0; i < 100 && transport.count("Pickup") == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if transport.count("Pickup") == 0 {
+		t.Fatal("discovered peer was not polled")
+	}
+
+	// refreshed by its advertisements past ExpireAfter
+	for i := 0; i < 8; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if !s.seen("fake://a") {
+			t.Fatal("refreshed peer expired")
+		}
+	}
+
+	// and dropped once they stop
+	time.Sleep(200 * time.Millisecond)
+	if s.seen("fake://a") || listed(t, s, "fake://a") {
+		t.Fatal("peer was not dropped after it stopped advertising")
+	}
+}
+
+func Test_P2P_MaxPeers_1(t *testing.T) {
+	transport := &fakeTransport{}
+	s := newTestP2P(transport)
+	s.MaxPeers = 1
+	pubsrv, _ := s.Node.ID()
+	defer func() {
+		s.forgetAll()
+		s.wg.Wait()
+	}()
+
+	if err := s.discover("fake://a", "a", transport, pubsrv); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.discover("fake://b", "b", transport, pubsrv); err == nil || listed(t, s, "fake://b") || !s.full {
+		t.Fatal("peer discovered past MaxPeers")
+	}
+
+	// room again once a peer is dropped
+	s.discoveredMtx.Lock()
+	s.forget("fake://a", s.discovered["fake://a"])
+	s.discoveredMtx.Unlock()
+	if err := s.discover("fake://b", "b", transport, pubsrv); err != nil || !listed(t, s, "fake://b") || s.full {
+		t.Fatal("peer not discovered after another was dropped: ", err)
+	}
+}
+
+func Test_P2P_PruneDiscovered_1(t *testing.T) {
+	s := newTestP2P(&fakeTransport{})
+	s.Node.AddPeer(discoveredPrefix+"fake://a", true, "fake://a", s.PeerGroup) // left by a crashed run
+	s.Node.AddPeer("manual", true, "fake://m", s.PeerGroup)
+
+	if err := s.pruneDiscovered(); err != nil {
+		t.Fatal(err)
+	}
+	if listed(t, s, "fake://a") {
+		t.Fatal("discovered peer of a previous run was not removed")
+	}
+	if !listed(t, s, "fake://m") {
+		t.Fatal("peer that was not discovered was removed")
+	}
+}
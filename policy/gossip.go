@@ -0,0 +1,280 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/ratnet"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// Defaults for the settings of a Gossip policy
+const (
+	DefaultGossipInterval = 10 * 60 * 1000 // milliseconds
+	DefaultGossipGroup    = "discovered"
+	DefaultMaxPeers       = 64
+	DefaultMaxPerList     = 16
+)
+
+// Gossip : defines a peer discovery Connection Policy, which periodically asks known peers for the peers
+// they share through GetPeerList and adds the new ones to a group of this node
+type Gossip struct {
+	// internal
+	wg        sync.WaitGroup
+	isRunning bool
+	err       error
+	done      chan struct{}
+
+	Transport api.Transport
+	node      api.Node
+
+	// Interval - milliseconds between rounds of asking peers for their lists
+	Interval int
+	// Sources - the group of peers asked for their lists, peers already in Group are asked as well
+	Sources string
+	// Group - the group discovered peers are added to, point a Poll policy at it to use them
+	Group string
+	// MaxPeers - the most peers Group may hold, 0 for no limit
+	MaxPeers int
+	// MaxPerList - the most new peers taken from any one list, 0 for no limit
+	MaxPerList int
+	// MaxAge - milliseconds after which a list is considered stale and ignored, 0 to accept lists of any age
+	MaxAge int
+	// RequireSigned - only accept lists carrying a valid signature of the peer's routing key, on by default.
+	// The key comes from the same peer as the list, so this only proves the peer holds it,
+	// the origin of a list is authenticated by listing the keys to accept in Trusted
+	RequireSigned bool
+	// Trusted - routing public keys (base64) whose lists are accepted, any key if empty,
+	// a non-empty list also requires a valid signature
+	Trusted []string
+}
+
+func init() {
+	ratnet.Policies["gossip"] = NewGossipFromMap // register this module by name (for deserialization support)
+}
+
+// NewGossipFromMap : Makes a new instance of this policy module from a map of arguments (for deserialization support)
+func NewGossipFromMap(transport api.Transport, node api.Node,
+	t map[string]interface{}) api.Policy {
+	g := NewGossip(transport, node)
+	if sources, ok := t["Sources"].(string); ok {
+		g.Sources = sources
+	}
+	if group, ok := t["Group"].(string); ok {
+		g.Group = group
+	}
+	if signed, ok := t["RequireSigned"].(bool); ok {
+		g.RequireSigned = signed
+	}
+	if trusted, ok := t["Trusted"].([]interface{}); ok {
+		for _, k := range trusted {
+			if key, ok := k.(string); ok {
+				g.Trusted = append(g.Trusted, key)
+			}
+		}
+	}
+	settings := map[string]*int{
+		"Interval":   &g.Interval,
+		"MaxPeers":   &g.MaxPeers,
+		"MaxPerList": &g.MaxPerList,
+		"MaxAge":     &g.MaxAge,
+	}
+	for k, v := range settings {
+		if f, ok := t[k].(float64); ok && f >= 0 {
+			*v = int(f)
+		}
+	}
+	return g
+}
+
+// NewGossip : Returns a new instance of a Gossip Connection Policy, asking the peers of the default group
+func NewGossip(transport api.Transport, node api.Node) *Gossip {
+	g := new(Gossip)
+	g.Transport = transport
+	g.node = node
+	g.Interval = DefaultGossipInterval
+	g.Group = DefaultGossipGroup
+	g.MaxPeers = DefaultMaxPeers
+	g.MaxPerList = DefaultMaxPerList
+	g.RequireSigned = true
+	return g
+}
+
+// MarshalJSON : Create a serialied representation of the config of this policy
+func (g *Gossip) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Policy":        "gossip",
+		"Transport":     g.Transport,
+		"Interval":      g.Interval,
+		"Sources":       g.Sources,
+		"Group":         g.Group,
+		"MaxPeers":      g.MaxPeers,
+		"MaxPerList":    g.MaxPerList,
+		"MaxAge":        g.MaxAge,
+		"RequireSigned": g.RequireSigned,
+		"Trusted":       g.Trusted})
+}
+
+// RunPolicy : Gossip
+func (g *Gossip) RunPolicy() error {
+	if g.isRunning {
+		return errors.New("Policy is already running")
+	}
+	if g.Group == g.Sources {
+		g.err = errors.New("Gossip Group must differ from Sources")
+		return g.err
+	}
+	if g.RequireSigned || len(g.Trusted) > 0 {
+		if err := checkSigning(g.node); err != nil {
+			g.err = errors.New("Gossip requires signed peer lists, but the routing key cannot sign and verify: " + err.Error())
+			return g.err
+		}
+	}
+	g.err = nil
+	g.isRunning = true
+	g.done = make(chan struct{})
+	events.Emit(g.node, api.Info, api.PolicyStarted, api.PolicyEvent{Policy: "gossip", Transport: g.Transport.Name()})
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		for {
+			g.round()
+			select {
+			case <-g.done:
+				return
+			case <-time.After(time.Duration(g.Interval) * time.Millisecond):
+			}
+		}
+	}()
+	return nil
+}
+
+// round - asks every enabled peer of Sources and Group for its list
+func (g *Gossip) round() {
+	var known []api.Peer
+	for _, group := range []string{g.Sources, g.Group} {
+		peers, err := g.node.GetPeers(group)
+		if err != nil {
+			events.Warning(g.node, "Gossip could not list peers: "+err.Error())
+			return
+		}
+		known = append(known, peers...)
+	}
+	for _, peer := range known {
+		if !peer.Enabled {
+			continue
+		}
+		list, err := g.fetch(peer.URI)
+		if err != nil {
+			events.Warning(g.node, "Gossip rejected the peer list of ", peer.URI, ": ", err.Error())
+			continue
+		}
+		if err := g.merge(list); err != nil {
+			events.Warning(g.node, "Gossip could not add peers: "+err.Error())
+		}
+	}
+}
+
+// fetch - gets the list of the host and checks it against the trust rules
+func (g *Gossip) fetch(host string) (*api.PeerList, error) {
+	raw, err := g.Transport.RPC(host, "ID")
+	if err != nil {
+		return nil, err
+	}
+	rpub, ok := raw.(bc.PubKey)
+	if !ok {
+		return nil, errors.New("type assertion failed to bc.PubKey")
+	}
+	raw, err = g.Transport.RPC(host, "GetPeerList")
+	if err != nil {
+		return nil, err
+	}
+	list, ok := raw.(*api.PeerList)
+	if !ok {
+		return nil, errors.New("type assertion failed to *api.PeerList")
+	}
+
+	if len(g.Trusted) > 0 {
+		trusted := false
+		for _, key := range g.Trusted {
+			trusted = trusted || key == list.Origin
+		}
+		if !trusted {
+			return nil, errors.New("origin is not trusted")
+		}
+	}
+	if err := list.Verify(rpub); err != nil {
+		if g.RequireSigned || len(g.Trusted) > 0 || (err != api.ErrUnsigned && err != api.ErrCannotVerify) {
+			return nil, err
+		}
+		events.Debug(g.node, "Gossip accepting unverified peer list of ", host, ": ", err.Error())
+	}
+	if g.MaxAge > 0 && time.Since(time.Unix(0, list.Time)) > time.Duration(g.MaxAge)*time.Millisecond {
+		return nil, errors.New("list is stale")
+	}
+	return list, nil
+}
+
+// merge - adds the peers of the list this node does not know yet to Group, within the limits
+func (g *Gossip) merge(list *api.PeerList) error {
+	known := make(map[string]bool)
+	for _, group := range []string{g.Sources, g.Group, api.SharedPeerGroup} {
+		peers, err := g.node.GetPeers(group)
+		if err != nil {
+			return err
+		}
+		for _, p := range peers {
+			known[p.URI] = true
+		}
+	}
+	members, err := g.node.GetPeers(g.Group)
+	if err != nil {
+		return err
+	}
+	size := len(members)
+
+	added := 0
+	for _, ad := range list.Peers {
+		if ad.URI == "" || known[ad.URI] || (ad.Transport != "" && ad.Transport != g.Transport.Name()) {
+			continue
+		}
+		if (g.MaxPeers > 0 && size >= g.MaxPeers) || (g.MaxPerList > 0 && added >= g.MaxPerList) {
+			break
+		}
+		// name discovered peers by URI, names are unique across groups and must not replace configured peers
+		if err := g.node.AddPeer("gossip:"+ad.URI, true, ad.URI, g.Group); err != nil {
+			return err
+		}
+		events.Info(g.node, "Gossip discovered peer ", ad.URI, " (", ad.Name, ")")
+		known[ad.URI] = true
+		size++
+		added++
+	}
+	return nil
+}
+
+// Stop : Stops this instance of Gossip from running, leaving the transport to the policies that listen with it
+func (g *Gossip) Stop() {
+	if !g.isRunning {
+		return
+	}
+	g.isRunning = false
+	close(g.done)
+	g.wg.Wait()
+	events.Emit(g.node, api.Info, api.PolicyStopped, api.PolicyEvent{Policy: "gossip", Transport: g.Transport.Name()})
+}
+
+// Status : Returns whether this policy is running
+func (g *Gossip) Status() api.PolicyStatus {
+	return policyStatus("gossip", g.isRunning, g.err)
+}
+
+// GetTransport : Returns the transports associated with this policy
+func (g *Gossip) GetTransport() api.Transport {
+	return g.Transport
+}
@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/awgh/bencrypt/rsa"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/internal/keytest"
+	"github.com/awgh/ratnet/nodes/ram"
+)
+
+func Test_Gossip_Fetch_Signed_1(t *testing.T) {
+	signer := ram.New(nil, keytest.New())
+	signer.AddPeer("shared1", true, "https://s:20001", api.SharedPeerGroup)
+	unsigned := ram.New(nil, new(rsa.KeyPair))
+	unsigned.AddPeer("shared1", true, "https://s:20001", api.SharedPeerGroup)
+	signerID, _ := signer.ID()
+
+	for _, c := range []struct {
+		remote   api.Node
+		required bool
+		trusted  []string
+		ok       bool
+	}{
+		{signer, true, nil, true},
+		{unsigned, true, nil, false},
+		{unsigned, false, nil, true},
+		{signer, false, []string{signerID.ToB64()}, true},
+		{signer, false, []string{"other"}, false},
+		// a trusted origin is only taken from a signed list
+		{unsigned, false, []string{unsignedOrigin(t, unsigned)}, false},
+	} {
+		g := NewGossip(&fakeTransport{remote: c.remote}, ram.New(nil, nil))
+		if !g.RequireSigned {
+			t.Fatal("gossip does not require signed lists by default")
+		}
+		g.RequireSigned = c.required
+		g.Trusted = c.trusted
+		list, err := g.fetch("peer")
+		if c.ok && (err != nil || len(list.Peers) != 1) {
+			t.Fatalf("%+v: list rejected: %v", c, err)
+		}
+		if !c.ok && err == nil {
+			t.Fatalf("%+v: list accepted", c)
+		}
+	}
+}
+
+// unsignedOrigin - the origin an unsigned list of the node claims
+func unsignedOrigin(t *testing.T, node api.Node) string {
+	list, err := node.GetPeerList()
+	if err != nil {
+		t.Fatal("unsigned list not returned: ", err)
+	}
+	if len(list.Signature) != 0 {
+		t.Fatal("list of a key that cannot sign carries a signature")
+	}
+	return list.Origin
+}
@@ -0,0 +1,206 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/ratnet"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// Defaults for the settings of a Mule policy
+const (
+	DefaultMuleInterval   = 5 * 1000 // milliseconds
+	DefaultEncounterBytes = 4 * 1024 * 1024
+	DefaultFlushAge       = 24 * 60 * 60 // seconds
+)
+
+// Mule : defines a store-and-forward Connection Policy for networks without continuous connectivity,
+// which tries the peers of a group in turn and exchanges everything it is allowed to with each one it reaches
+type Mule struct {
+	// internal
+	wg        sync.WaitGroup
+	isRunning bool
+	err       error
+	done      chan struct{}
+
+	// cursors, saved by the node so they survive restarts
+	peers *PeerTable
+	next  int // where the rotation resumes
+
+	Transport api.Transport
+	node      api.Node
+
+	// Group - the peers to try, in the order of their URIs
+	Group string
+	// Interval - milliseconds between rounds of trying peers
+	Interval int
+	// Encounters - the most peers synced with in one round, 0 for every reachable one
+	Encounters int
+	// EncounterBytes - stop exchanging with a peer once this many bytes have moved, 0 for no limit,
+	// checked after each bundle so an encounter can go over by up to one bundle of the transport's ByteLimit
+	EncounterBytes int64
+	// EncounterTime - milliseconds an encounter may last, 0 for no limit
+	EncounterTime int
+	// FlushAge - seconds outbox messages are carried for, 0 to keep them until they expire
+	FlushAge int
+}
+
+func init() {
+	ratnet.Policies["mule"] = NewMuleFromMap // register this module by name (for deserialization support)
+}
+
+// NewMuleFromMap : Makes a new instance of this policy module from a map of arguments (for deserialization support)
+func NewMuleFromMap(transport api.Transport, node api.Node,
+	t map[string]interface{}) api.Policy {
+	group, _ := t["Group"].(string)
+	m := NewMule(transport, node, group)
+	if n, ok := t["EncounterBytes"].(float64); ok && n >= 0 {
+		m.EncounterBytes = int64(n)
+	}
+	settings := map[string]*int{
+		"Interval":      &m.Interval,
+		"Encounters":    &m.Encounters,
+		"EncounterTime": &m.EncounterTime,
+		"FlushAge":      &m.FlushAge,
+	}
+	for k, v := range settings {
+		if f, ok := t[k].(float64); ok && f >= 0 {
+			*v = int(f)
+		}
+	}
+	return m
+}
+
+// NewMule : Returns a new instance of a Mule Connection Policy for the peers of the given group
+func NewMule(transport api.Transport, node api.Node, group string) *Mule {
+	m := new(Mule)
+	m.Transport = transport
+	m.node = node
+	m.Group = group
+	m.Interval = DefaultMuleInterval
+	m.EncounterBytes = DefaultEncounterBytes
+	m.EncounterTime = DefaultSessionTime
+	m.FlushAge = DefaultFlushAge
+	return m
+}
+
+// MarshalJSON : Create a serialied representation of the config of this policy
+func (m *Mule) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Policy":         "mule",
+		"Transport":      m.Transport,
+		"Group":          m.Group,
+		"Interval":       m.Interval,
+		"Encounters":     m.Encounters,
+		"EncounterBytes": m.EncounterBytes,
+		"EncounterTime":  m.EncounterTime,
+		"FlushAge":       m.FlushAge})
+}
+
+// RunPolicy : Mule
+func (m *Mule) RunPolicy() error {
+	if m.isRunning {
+		return errors.New("Policy is already running")
+	}
+	pubsrv, err := m.node.ID()
+	if err != nil {
+		m.err = err
+		return err
+	}
+	m.err = nil
+	m.peers = NewPeerTable(true)
+	m.isRunning = true
+	m.done = make(chan struct{})
+	events.Emit(m.node, api.Info, api.PolicyStarted, api.PolicyEvent{Policy: "mule", Transport: m.Transport.Name()})
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		lastFlush := time.Now()
+		for {
+			m.round(pubsrv)
+			if m.FlushAge > 0 && time.Since(lastFlush) >= time.Hour {
+				m.node.FlushOutbox(int64(m.FlushAge))
+				lastFlush = time.Now()
+			}
+			select {
+			case <-m.done:
+				return
+			case <-time.After(time.Duration(m.Interval) * time.Millisecond):
+			}
+		}
+	}()
+	return nil
+}
+
+// round - tries the enabled peers of the group, starting after the last one tried,
+// until Encounters of them have been synced with or all of them have been tried
+func (m *Mule) round(pubsrv bc.PubKey) {
+	all, err := m.node.GetPeers(m.Group)
+	if err != nil {
+		events.Warning(m.node, "Mule could not list peers: "+err.Error())
+		return
+	}
+	var peers []api.Peer
+	for _, p := range all {
+		if p.Enabled {
+			peers = append(peers, p)
+		}
+	}
+	if len(peers) == 0 {
+		return
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].URI < peers[j].URI })
+
+	start := m.next % len(peers)
+	synced := 0
+	for i := 0; i < len(peers) && (m.Encounters == 0 || synced < m.Encounters); i++ {
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+		host := peers[(start+i)%len(peers)].URI
+		m.next = (start + i + 1) % len(peers)
+		happy, err := PollServerWith(m.Transport, m.node, m.peers, host, pubsrv, PollOptions{
+			Drain:    true,
+			MaxBytes: m.EncounterBytes,
+			MaxTime:  time.Duration(m.EncounterTime) * time.Millisecond,
+		})
+		if !happy {
+			if err != nil {
+				events.Debug(m.node, "Mule could not reach ", host, ": ", err.Error())
+			}
+			continue
+		}
+		events.Info(m.node, "Mule synced with ", host)
+		synced++
+	}
+}
+
+// Stop : Stops this instance of Mule, after the encounter in progress
+func (m *Mule) Stop() {
+	if !m.isRunning {
+		return
+	}
+	m.isRunning = false
+	close(m.done)
+	m.wg.Wait()
+	events.Emit(m.node, api.Info, api.PolicyStopped, api.PolicyEvent{Policy: "mule", Transport: m.Transport.Name()})
+}
+
+// Status : Returns whether this policy is running
+func (m *Mule) Status() api.PolicyStatus {
+	return policyStatus("mule", m.isRunning, m.err)
+}
+
+// GetTransport : Returns the transports associated with this policy
+func (m *Mule) GetTransport() api.Transport {
+	return m.Transport
+}
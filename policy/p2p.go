@@ -1,13 +1,15 @@
 package policy
 
 import (
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"net/url"
-	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/awgh/ratnet"
@@ -25,8 +27,8 @@ import (
 //
 type P2P struct {
 	negotiationRank uint64
-	// last poll times - moving to peerTable
-	// lastPollLocal, lastPollRemote int64
+	// last poll times
+	peers *PeerTable
 
 	ListenInterval    int
 	AdvertiseInterval int
@@ -39,10 +41,51 @@ type P2P struct {
 	Transport     api.Transport
 	Node          api.Node
 
+	// IPv6 - use the IPv6 mDNS group ff02::fb instead of 224.0.0.251
+	IPv6 bool
+	// Interface - name of the network interface to advertise and listen on, "" to let the system choose
+	Interface string
+	iface     *net.Interface
+
+	// RequireSigned - ignore advertisements without a valid signature of the advertiser's routing key,
+	// off by default: then unsigned advertisements are accepted, and so is anyone on the LAN.
+	// A signature only proves the advertiser holds the key it names, with an empty Trusted any key passes,
+	// including one generated just for the advertisement
+	RequireSigned bool
+	// Trusted - routing public keys (base64) whose advertisements are accepted, a non-empty list also requires
+	// a valid signature; empty by default, which accepts a signature by any key, so an advertisement is only
+	// authenticated as coming from a known node when its key is listed here
+	Trusted []string
+	// MaxPeers - the most discovered peers polled at the same time, 0 for no limit,
+	// advertisements of further peers are ignored until one of them expires
+	MaxPeers int
+	// MaxAge - milliseconds after which a signed advertisement is ignored as a replay, 0 to accept any age
+	MaxAge int
+
+	// PeerGroup - the group of the node's peer list that discovered peers appear in while they are polled
+	PeerGroup string
+	// ExpireAfter - milliseconds without an advertisement after which a discovered peer is dropped, 0 to keep it
+	ExpireAfter int
+
+	discoveredMtx sync.Mutex
+	discovered    map[string]*discoveredPeer // by advertised address
+	full          bool                       // MaxPeers was reached, logged once until a peer is dropped
+	done          chan struct{}
+	wg            sync.WaitGroup
+
 	listenSocket *net.UDPConn
 	dialSocket   *net.UDPConn
+	err          error
 }
 
+// Defaults for the settings of a P2P policy
+const (
+	DefaultP2PMaxPeers = 32
+	DefaultAdvertAge   = 60 * 1000 // milliseconds
+	DefaultP2PGroup    = "p2p"
+	DefaultP2PExpiry   = 5 * 60 * 1000 // milliseconds
+)
+
 var (
 	maxDatagramSize = 4096
 
@@ -50,6 +93,10 @@ var (
 		IP:   net.IPv4(224, 0, 0, 251),
 		Port: 5353,
 	}
+	multicastAddr6 = &net.UDPAddr{
+		IP:   net.ParseIP("ff02::fb"),
+		Port: 5353,
+	}
 )
 
 func init() {
@@ -60,9 +107,49 @@ func init() {
 func NewP2PFromMap(transport api.Transport, node api.Node, p map[string]interface{}) api.Policy {
 	listenURI := p["ListenURI"].(string)
 	adminMode := p["AdminMode"].(bool)
-	listenInterval := p["ListenInterval"].(int)
-	advertiseInterval := p["AdvertiseInterval"].(int)
-	return NewP2P(transport, listenURI, node, adminMode, listenInterval, advertiseInterval)
+	listenInterval := intArg(p["ListenInterval"])
+	advertiseInterval := intArg(p["AdvertiseInterval"])
+	s := NewP2P(transport, listenURI, node, adminMode, listenInterval, advertiseInterval)
+	if ipv6, ok := p["IPv6"].(bool); ok {
+		s.IPv6 = ipv6
+	}
+	if iface, ok := p["Interface"].(string); ok {
+		s.Interface = iface
+	}
+	if signed, ok := p["RequireSigned"].(bool); ok {
+		s.RequireSigned = signed
+	}
+	if trusted, ok := p["Trusted"].([]interface{}); ok {
+		for _, k := range trusted {
+			if key, ok := k.(string); ok {
+				s.Trusted = append(s.Trusted, key)
+			}
+		}
+	}
+	if _, ok := p["MaxPeers"]; ok {
+		s.MaxPeers = intArg(p["MaxPeers"])
+	}
+	if _, ok := p["MaxAge"]; ok {
+		s.MaxAge = intArg(p["MaxAge"])
+	}
+	if group, ok := p["PeerGroup"].(string); ok {
+		s.PeerGroup = group
+	}
+	if _, ok := p["ExpireAfter"]; ok {
+		s.ExpireAfter = intArg(p["ExpireAfter"])
+	}
+	return s
+}
+
+// intArg - reads a number from a config map, which holds float64s when it was decoded from JSON
+func intArg(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
 }
 
 // NewP2P : Returns a new instance of a P2P Connection Policy
@@ -76,6 +163,11 @@ func NewP2P(transport api.Transport, listenURI string, node api.Node, adminMode
 	s.Node = node
 	s.ListenInterval = listenInterval
 	s.AdvertiseInterval = advertiseInterval
+	s.peers = NewPeerTable(false)
+	s.MaxPeers = DefaultP2PMaxPeers
+	s.MaxAge = DefaultAdvertAge
+	s.PeerGroup = DefaultP2PGroup
+	s.ExpireAfter = DefaultP2PExpiry
 
 	s.rerollNegotiationRank()
 	return s
@@ -89,44 +181,158 @@ func (s *P2P) MarshalJSON() (b []byte, e error) {
 		"AdminMode":         s.AdminMode,
 		"Transport":         s.Transport,
 		"ListenInterval":    s.ListenInterval,
-		"AdvertiseInterval": s.AdvertiseInterval})
+		"AdvertiseInterval": s.AdvertiseInterval,
+		"IPv6":              s.IPv6,
+		"Interface":         s.Interface,
+		"RequireSigned":     s.RequireSigned,
+		"Trusted":           s.Trusted,
+		"MaxPeers":          s.MaxPeers,
+		"MaxAge":            s.MaxAge,
+		"PeerGroup":         s.PeerGroup,
+		"ExpireAfter":       s.ExpireAfter})
 }
 
-func (s *P2P) initListenSocket() {
-	socket, err := net.ListenMulticastUDP("udp4", nil, multicastAddr)
+// resolveInterface - looks up the configured interface, or picks one for IPv6,
+// where multicast to ff02::fb is scoped to a single link
+func (s *P2P) resolveInterface() error {
+	s.iface = nil
+	if s.Interface != "" {
+		iface, err := net.InterfaceByName(s.Interface)
+		if err != nil {
+			return err
+		}
+		s.iface = iface
+		return nil
+	}
+	if !s.IPv6 {
+		return nil
+	}
+	ifaces, err := net.Interfaces()
 	if err != nil {
-		events.Critical(s.Node, err.Error())
+		return err
 	}
-	err = socket.SetReadBuffer(maxDatagramSize)
+	for i := range ifaces {
+		flags := ifaces[i].Flags
+		if flags&net.FlagUp == 0 || flags&net.FlagMulticast == 0 || flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if _, err := interfaceAddr(&ifaces[i], true); err == nil {
+			s.iface = &ifaces[i]
+			return nil
+		}
+	}
+	return errors.New("No multicast interface with an IPv6 address")
+}
+
+// interfaceAddr - returns an address of the interface in the given family, preferring global ones to link-local
+func interfaceAddr(iface *net.Interface, ipv6 bool) (net.IP, error) {
+	addrs, err := iface.Addrs()
 	if err != nil {
-		events.Critical(s.Node, err.Error())
+		return nil, err
+	}
+	var linkLocal net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || (ipnet.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		if ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP, nil
+		}
+		if linkLocal == nil && ipnet.IP.IsLinkLocalUnicast() {
+			linkLocal = ipnet.IP
+		}
+	}
+	if linkLocal == nil {
+		return nil, errors.New("Interface " + iface.Name + " has no usable address")
+	}
+	return linkLocal, nil
+}
+
+func (s *P2P) initListenSocket() error {
+	network, group := "udp4", multicastAddr
+	if s.IPv6 {
+		network, group = "udp6", multicastAddr6
+	}
+	socket, err := net.ListenMulticastUDP(network, s.iface, group)
+	if err != nil {
+		return err
+	}
+	if err := socket.SetReadBuffer(maxDatagramSize); err != nil {
+		socket.Close()
+		return err
 	}
 	s.listenSocket = socket
+	return nil
 }
 
 func (s *P2P) initDialSocket() error {
-	socket, err := net.DialUDP("udp", nil, multicastAddr)
+	port, err := listenPort(s.ListenURI)
 	if err != nil {
-		events.Critical(s.Node, err.Error())
+		return err
+	}
+	network, group := "udp4", *multicastAddr
+	var laddr *net.UDPAddr
+	if s.IPv6 {
+		network, group = "udp6", *multicastAddr6
+		group.Zone = s.iface.Name
+	} else if s.iface != nil {
+		// Linux sends multicast out of the interface that owns the source address
+		ip, err := interfaceAddr(s.iface, false)
+		if err != nil {
+			return err
+		}
+		laddr = &net.UDPAddr{IP: ip}
+	}
+	socket, err := net.DialUDP(network, laddr, &group)
+	if err != nil {
+		return err
 	}
-	s.dialSocket = socket
 
 	//
 	// prepare the service string
-	hp := strings.Split(s.ListenURI, ":") // listen URI has no protocol, is in format [HOST]:PORT
-	if len(hp) < 1 {
-		return errors.New("Split Host/Port failed with no port")
-	}
-	port := hp[len(hp)-1]
-	ip := strings.Split(s.dialSocket.LocalAddr().String(), ":") // LocalAddr has no protocol, is in format [HOST]:PORT
-	if len(ip) < 1 {
-		return errors.New("Split Host/Port failed")
+	ip := socket.LocalAddr().(*net.UDPAddr).IP
+	if s.iface != nil {
+		if ip, err = interfaceAddr(s.iface, s.IPv6); err != nil {
+			socket.Close()
+			return err
+		}
 	}
-	s.localAddress = s.Transport.Name() + "://" + ip[0] + ":" + port
+	// advertised without a zone, receivers add the one of the link the advertisement came in on
+	s.localAddress = serviceURL(s.Transport.Name(), ip, port)
+	s.dialSocket = socket
 
 	return nil
 }
 
+// listenPort - returns the port of a listen URI, which has no protocol and is in the format [HOST]:PORT
+func listenPort(listenURI string) (string, error) {
+	_, port, err := net.SplitHostPort(listenURI)
+	if err == nil && port == "" {
+		err = errors.New("Listen URI has no port: " + listenURI)
+	}
+	return port, err
+}
+
+// serviceURL - returns the URL advertised for the transport, with IPv6 addresses in brackets
+// and IPv4-mapped ones in their IPv4 form
+func serviceURL(scheme string, ip net.IP, port string) string {
+	return scheme + "://" + net.JoinHostPort(ip.String(), port)
+}
+
+// targetHost - returns the host to poll for an advertised URL, adding the zone of the link
+// the advertisement came in on to IPv6 link-local addresses
+func targetHost(u *url.URL, src *net.UDPAddr) string {
+	ip := net.ParseIP(u.Hostname())
+	if ip != nil && ip.To4() == nil && ip.IsLinkLocalUnicast() && src != nil && src.Zone != "" {
+		if u.Port() == "" {
+			return "[" + ip.String() + "%" + src.Zone + "]"
+		}
+		return net.JoinHostPort(ip.String()+"%"+src.Zone, u.Port())
+	}
+	return u.Host
+}
+
 func (s *P2P) rerollNegotiationRank() {
 	rand.Seed(time.Now().UnixNano()) // otherwise go defaults to seed(1). really.
 	s.negotiationRank = uint64(rand.Uint32())<<32 + uint64(rand.Uint32())
@@ -135,45 +341,88 @@ func (s *P2P) rerollNegotiationRank() {
 // RunPolicy : Executes the policy as a goroutine
 //
 func (s *P2P) RunPolicy() error {
-
-	s.initListenSocket()
-	if err := s.initDialSocket(); err != nil {
-		return err
+	if s.IsListening {
+		return errors.New("Policy is already running")
+	}
+	if s.RequireSigned || len(s.Trusted) > 0 {
+		if err := checkSigning(s.Node); err != nil {
+			s.err = errors.New("P2P requires signed advertisements, but the routing key cannot sign and verify: " + err.Error())
+			return s.err
+		}
+	}
+	if err := s.pruneDiscovered(); err != nil {
+		events.Warning(s.Node, "p2p could not remove discovered peers of a previous run: "+err.Error())
+	}
+	s.err = s.resolveInterface()
+	if s.err == nil {
+		s.err = s.initListenSocket()
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if s.err = s.initDialSocket(); s.err != nil {
+		s.listenSocket.Close()
+		return s.err
 	}
 
 	s.Transport.Listen(s.ListenURI, s.AdminMode)
+	s.discovered = make(map[string]*discoveredPeer)
+	s.full = false
+	s.done = make(chan struct{})
 	s.IsListening = true
+	events.Emit(s.Node, api.Info, api.PolicyStarted, api.PolicyEvent{Policy: "p2p", Transport: s.Transport.Name()})
 
+	s.wg.Add(2)
 	go s.mdnsListen()
 	go func() {
+		defer s.wg.Done()
 		for s.IsListening {
 			if err := s.mdnsAdvertise(); err != nil {
 				events.Warning(s.Node, "mdnsAdvertise errored: "+err.Error())
 			}
-			time.Sleep(time.Duration(s.AdvertiseInterval) * time.Millisecond) // update interval
+			select {
+			case <-s.done:
+				return
+			case <-time.After(time.Duration(s.AdvertiseInterval) * time.Millisecond): // update interval
+			}
 		}
 	}()
+	if s.ExpireAfter > 0 {
+		s.wg.Add(1)
+		go s.expireLoop()
+	}
 	return nil
 }
 
 // Stop : Stops a policy
 //
 func (s *P2P) Stop() {
+	if !s.IsListening {
+		return
+	}
 	s.Transport.Stop()
 	s.IsListening = false
+	close(s.done)
 
 	s.listenSocket.Close()
 	s.dialSocket.Close()
+	s.forgetAll()
+	s.wg.Wait()
+	events.Emit(s.Node, api.Info, api.PolicyStopped, api.PolicyEvent{Policy: "p2p", Transport: s.Transport.Name()})
 }
 
 func (s *P2P) mdnsListen() error {
-
-	peerlist := make(map[string]interface{})
+	defer s.wg.Done()
 
 	for s.IsListening {
 		b := make([]byte, maxDatagramSize)
 		conn := s.listenSocket
-		if _, _, err := conn.ReadFromUDP(b); err != nil {
+		_, src, err := conn.ReadFromUDP(b)
+		if err != nil {
+			if s.IsListening { // not closed by Stop
+				events.Error(s.Node, "p2p stopped listening for advertisements: "+err.Error())
+				s.err = err
+			}
 			return err
 		}
 		msg := &dns.Msg{}
@@ -183,6 +432,8 @@ func (s *P2P) mdnsListen() error {
 		var targetNegRank uint64
 		prefixLen := 3 // .rn or .ng
 
+		// anyone on the link can send these, so malformed ones are skipped rather than ending the listener
+		malformed := false
 		for _, q := range msg.Question {
 			if len(q.Name) > prefixLen {
 				if q.Name[:prefixLen] == "rn." {
@@ -190,22 +441,33 @@ func (s *P2P) mdnsListen() error {
 					hexed := qn[1]
 					dehexed, err := hex.DecodeString(hexed)
 					if err != nil {
-						return err
+						malformed = true
+						continue
 					}
 					target = string(dehexed)
 				} else if q.Name[:prefixLen] == "ng." {
 					qm := strings.Split(q.String(), ".")
 					hexed := qm[1]
 					dehexed, err := hex.DecodeString(hexed)
-					if err != nil {
-						return err
+					if err != nil || len(dehexed) != 8 {
+						malformed = true
+						continue
 					}
 					targetNegRank = binary.LittleEndian.Uint64(dehexed)
 				}
 			}
 		}
-		_, exists := peerlist[target]
-		if !exists && (target != "" && targetNegRank > 0 && s.localAddress != target) {
+		if malformed {
+			events.Debug(s.Node, "p2p ignoring malformed advertisement from ", src.String())
+			continue
+		}
+		auth := make(map[string]string) // the advertTime, advertKey and advertSig records
+		for _, rr := range msg.Extra {
+			if txt, ok := rr.(*dns.TXT); ok {
+				auth[txt.Hdr.Name] = strings.Join(txt.Txt, "")
+			}
+		}
+		if target != "" && targetNegRank > 0 && s.localAddress != target {
 			/*
 				Negotiation:
 					- The lowest rank does a push/pull
@@ -214,6 +476,13 @@ func (s *P2P) mdnsListen() error {
 						 don't want to reroll because that way the push/pull relationships can be more long-lived)
 			*/
 			if s.negotiationRank <= targetNegRank {
+				if err := s.authenticate(target, targetNegRank, auth); err != nil {
+					events.Debug(s.Node, "p2p ignoring advertisement of ", target, ": ", err.Error())
+					continue
+				}
+				if s.seen(target) {
+					continue
+				}
 				pubsrv, err := s.Node.ID()
 				if err != nil {
 					events.Critical(s.Node, "Couldn't get routing key in P2P.RunPolicy:\n"+err.Error())
@@ -221,36 +490,128 @@ func (s *P2P) mdnsListen() error {
 				events.Info(s.Node, "Won Negotiation, Push/Pulling target/me ", target, s.ListenURI)
 				u, err := url.Parse(target)
 				if err != nil {
-					return err
+					events.Warning(s.Node, "p2p could not parse advertised address: "+err.Error())
+					continue
 				}
 
 				t := make(map[string]interface{})
-				fromMapFn := ratnet.Transports[u.Scheme]
+				fromMapFn, ok := ratnet.Transports[u.Scheme]
+				if !ok {
+					events.Warning(s.Node, "p2p advertised transport is not registered: "+u.Scheme)
+					continue
+				}
 				trans := fromMapFn(s.Node, t)
 				//todo: cache transports?
-				peerlist[target] = trans
-				go func() {
-					for s.IsListening {
-						st := time.Now()
-						if happy, err := PollServer(trans, s.Node, target[len(u.Scheme)+3:], pubsrv); !happy {
-							if err != nil {
-								events.Warning(s.Node, err.Error())
-							}
-						}
-						st2 := time.Now()
-						events.Debug(s.Node, "p2p PollServer took: %s\n", st2.Sub(st).String())
-						runtime.GC()
-						st3 := time.Now()
-						events.Debug(s.Node, "p2p GC took: %s\n", st3.Sub(st2).String())
-						time.Sleep(time.Duration(s.ListenInterval) * time.Millisecond) // update interval
-					}
-				}()
+				if err := s.discover(target, targetHost(u, src), trans, pubsrv); err != nil {
+					events.Debug(s.Node, "p2p not polling ", target, ": ", err.Error())
+				}
 			}
 		}
 	}
 	return nil
 }
 
+// names of the TXT records carrying the signature of an advertisement
+const (
+	advertTime = "ts.local."
+	advertKey  = "pk.local."
+	advertSig  = "sg.local."
+)
+
+// advertBytes - the part of an advertisement covered by its signature
+func advertBytes(target string, rank uint64, ts int64) []byte {
+	b := make([]byte, 16, 16+len(target))
+	binary.LittleEndian.PutUint64(b, rank)
+	binary.LittleEndian.PutUint64(b[8:], uint64(ts))
+	return append(b, target...)
+}
+
+// txtRecord - returns a TXT record holding value, split into strings of the longest length allowed
+func txtRecord(name, value string) *dns.TXT {
+	rr := &dns.TXT{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}}
+	for len(value) > 255 {
+		rr.Txt = append(rr.Txt, value[:255])
+		value = value[255:]
+	}
+	rr.Txt = append(rr.Txt, value)
+	return rr
+}
+
+// authenticate - checks the signature of an advertisement against RequireSigned, Trusted and MaxAge
+func (s *P2P) authenticate(target string, rank uint64, auth map[string]string) error {
+	required := s.RequireSigned || len(s.Trusted) > 0
+	key, sig := auth[advertKey], auth[advertSig]
+	if key == "" || sig == "" {
+		if required {
+			return errors.New("advertisement is not signed")
+		}
+		return nil
+	}
+	if len(s.Trusted) > 0 {
+		trusted := false
+		for _, k := range s.Trusted {
+			trusted = trusted || k == key
+		}
+		if !trusted {
+			return errors.New("advertiser is not trusted")
+		}
+	}
+	ts, err := strconv.ParseInt(auth[advertTime], 10, 64)
+	if err != nil {
+		return errors.New("advertisement has no valid time")
+	}
+	if maxAge := time.Duration(s.MaxAge) * time.Millisecond; maxAge > 0 {
+		if age := time.Since(time.Unix(0, ts)); age > maxAge || age < -maxAge {
+			return errors.New("advertisement is stale")
+		}
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return err
+	}
+	id, err := s.Node.ID()
+	if err != nil {
+		return err
+	}
+	pub := id.Clone() // same key type as ours
+	if err := pub.FromB64(key); err != nil {
+		return err
+	}
+	valid, err := api.Verify(pub, advertBytes(target, rank, ts), signature)
+	if err == api.ErrCannotVerify && !required {
+		return nil
+	} else if err != nil {
+		return err
+	} else if !valid {
+		return errors.New("advertisement signature is invalid")
+	}
+	return nil
+}
+
+// signAdvert - returns the records carrying the signature of an advertisement, none if the routing key cannot sign
+func (s *P2P) signAdvert(target string, rank uint64) ([]dns.RR, error) {
+	signer, ok := s.Node.(api.RoutingSigner)
+	if !ok {
+		return nil, nil
+	}
+	ts := time.Now().UnixNano()
+	sig, err := signer.SignRouting(advertBytes(target, rank, ts))
+	if err == api.ErrCannotSign {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	id, err := s.Node.ID()
+	if err != nil {
+		return nil, err
+	}
+	return []dns.RR{
+		txtRecord(advertTime, strconv.FormatInt(ts, 10)),
+		txtRecord(advertKey, id.ToB64()),
+		txtRecord(advertSig, base64.StdEncoding.EncodeToString(sig)),
+	}, nil
+}
+
 func (s *P2P) mdnsAdvertise() error {
 
 	events.Info(s.Node, "mdns Advertising...")
@@ -270,6 +631,12 @@ func (s *P2P) mdnsAdvertise() error {
 	m.Question = make([]dns.Question, 2)
 	m.Question[0] = dns.Question{Name: oname, Qtype: dns.TypeSRV, Qclass: dns.ClassINET}
 	m.Question[1] = dns.Question{Name: oneg, Qtype: dns.TypeSRV, Qclass: dns.ClassINET}
+	extra, err := s.signAdvert(s.localAddress, s.negotiationRank)
+	if err != nil {
+		events.Warning(s.Node, "Signing advertisement failed with:", err.Error())
+		return err
+	}
+	m.Extra = extra
 	msgBytes, err := m.Pack()
 	if err != nil {
 		events.Warning(s.Node, "Pack failed with:", err.Error(), oname)
@@ -284,6 +651,11 @@ func (s *P2P) mdnsAdvertise() error {
 	return nil
 }
 
+// Status : Returns whether this policy is running, or the error that stopped it listening for advertisements
+func (s *P2P) Status() api.PolicyStatus {
+	return policyStatus("p2p", s.IsListening, s.err)
+}
+
 // GetTransport : Returns the transports associated with this policy
 //
 func (s *P2P) GetTransport() api.Transport {
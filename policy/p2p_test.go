@@ -0,0 +1,193 @@
+package policy
+
+import (
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/bencrypt/rsa"
+	"github.com/awgh/ratnet/internal/keytest"
+	"github.com/awgh/ratnet/nodes/ram"
+	"github.com/miekg/dns"
+)
+
+func Test_P2P_ListenPort_1(t *testing.T) {
+	for _, c := range []struct {
+		uri  string
+		port string // "" for an error
+	}{
+		{":20005", "20005"},
+		{"0.0.0.0:20005", "20005"},
+		{"[::]:20005", "20005"},
+		{"[fe80::1%eth0]:20005", "20005"},
+		{"[::ffff:10.0.0.1]:20005", "20005"},
+		{"fe80::1:20005", ""},
+		{"localhost", ""},
+		{"[::1]:", ""},
+	} {
+		port, err := listenPort(c.uri)
+		if c.port == "" && err == nil {
+			t.Fatalf("%s: expected an error, got port %q", c.uri, port)
+		}
+		if c.port != "" && (err != nil || port != c.port) {
+			t.Fatalf("%s: got port %q, error %v, expected %q", c.uri, port, err, c.port)
+		}
+	}
+}
+
+func Test_P2P_ServiceURL_1(t *testing.T) {
+	for _, c := range []struct {
+		ip   string
+		want string
+	}{
+		{"10.0.0.1", "https://10.0.0.1:20005"},
+		{"::ffff:10.0.0.1", "https://10.0.0.1:20005"},
+		{"2001:db8::1", "https://[2001:db8::1]:20005"},
+		{"fe80::1", "https://[fe80::1]:20005"},
+	} {
+		if got := serviceURL("https", net.ParseIP(c.ip), "20005"); got != c.want {
+			t.Fatalf("%s: advertised %s, expected %s", c.ip, got, c.want)
+		}
+	}
+}
+
+func Test_P2P_TargetHost_1(t *testing.T) {
+	eth0 := &net.UDPAddr{IP: net.ParseIP("fe80::2"), Zone: "eth0"}
+	for _, c := range []struct {
+		url  string
+		src  *net.UDPAddr
+		want string
+	}{
+		{"https://[fe80::1]:20005", eth0, "[fe80::1%eth0]:20005"},
+		{"https://[fe80::1]", eth0, "[fe80::1%eth0]"},
+		{"https://[fe80::1%25wlan0]:20005", eth0, "[fe80::1%wlan0]:20005"}, // advertised zone is kept
+		{"https://[fe80::1]:20005", nil, "[fe80::1]:20005"},
+		{"https://[fe80::1]:20005", &net.UDPAddr{IP: net.ParseIP("fe80::2")}, "[fe80::1]:20005"},
+		{"https://[2001:db8::1]:20005", eth0, "[2001:db8::1]:20005"},
+		{"https://[::ffff:169.254.0.1]:20005", eth0, "[::ffff:169.254.0.1]:20005"}, // IPv4, no zone
+		{"https://10.0.0.1:20005", eth0, "10.0.0.1:20005"},
+	} {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := targetHost(u, c.src); got != c.want {
+			t.Fatalf("%s: polling %s, expected %s", c.url, got, c.want)
+		}
+	}
+
+	// what initDialSocket advertises on a link is polled on that link
+	u, err := url.Parse(serviceURL("https", net.ParseIP("fe80::1"), "20005"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := targetHost(u, eth0); got != "[fe80::1%eth0]:20005" {
+		t.Fatalf("advertised link-local address polled as %s", got)
+	}
+}
+
+// signedAuth - the signature records of an advertisement made at ts, as mdnsListen collects them
+func signedAuth(key *keytest.KeyPair, target string, rank uint64, ts int64) map[string]string {
+	sig, _ := key.Sign(advertBytes(target, rank, ts))
+	return map[string]string{
+		advertTime: strconv.FormatInt(ts, 10),
+		advertKey:  key.GetPubKey().ToB64(),
+		advertSig:  base64.StdEncoding.EncodeToString(sig),
+	}
+}
+
+func Test_P2P_Authenticate_1(t *testing.T) {
+	const target = "https://10.0.0.1:20005"
+	const rank = 42
+	key, other := keytest.New(), keytest.New()
+	now := time.Now().UnixNano()
+
+	// what signAdvert sends is what authenticate accepts
+	advertiser := &P2P{Node: ram.New(nil, key)}
+	records, err := advertiser.signAdvert(target, rank)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("advertisement not signed: %v %v", records, err)
+	}
+	sent := make(map[string]string)
+	for _, rr := range records {
+		txt := rr.(*dns.TXT)
+		sent[txt.Hdr.Name] = strings.Join(txt.Txt, "")
+	}
+
+	forged := signedAuth(other, target, rank, now)
+	forged[advertKey] = key.GetPubKey().ToB64()
+
+	for _, c := range []struct {
+		name   string
+		s      *P2P
+		target string
+		auth   map[string]string
+		ok     bool
+	}{
+		{"signed", &P2P{Trusted: []string{key.GetPubKey().ToB64()}}, target, sent, true},
+		{"untrusted", &P2P{Trusted: []string{other.GetPubKey().ToB64()}}, target, sent, false},
+		{"forged", &P2P{Trusted: []string{key.GetPubKey().ToB64()}}, target, forged, false},
+		{"altered", &P2P{Trusted: []string{key.GetPubKey().ToB64()}}, "https://10.6.6.6:20005", sent, false},
+		{"stale", &P2P{MaxAge: 1000}, target, signedAuth(key, target, rank, now-int64(time.Hour)), false},
+		{"future", &P2P{MaxAge: 1000}, target, signedAuth(key, target, rank, now+int64(time.Hour)), false},
+		{"unsigned required", &P2P{RequireSigned: true}, target, map[string]string{}, false},
+		{"unsigned trusted", &P2P{Trusted: []string{key.GetPubKey().ToB64()}}, target, map[string]string{}, false},
+		// the defaults accept anything that is not provably wrong
+		{"unsigned default", &P2P{}, target, map[string]string{}, true},
+		{"self-signed default", &P2P{}, target, signedAuth(other, target, rank, now), true},
+		{"forged default", &P2P{}, target, forged, false},
+	} {
+		c.s.Node = ram.New(nil, keytest.New())
+		if err := c.s.authenticate(c.target, rank, c.auth); (err == nil) != c.ok {
+			t.Fatalf("%s: accepted %v, expected %v: %v", c.name, err == nil, c.ok, err)
+		}
+	}
+}
+
+func Test_P2P_Authenticate_Ecc_1(t *testing.T) {
+	const target = "https://10.0.0.1:20005"
+	key := new(ecc.KeyPair)
+	key.GenerateKey()
+	advertiser := &P2P{Node: ram.New(nil, key)}
+	records, err := advertiser.signAdvert(target, 1)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("ecc routing key did not sign the advertisement: %v %v", records, err)
+	}
+	sent := make(map[string]string)
+	for _, rr := range records {
+		txt := rr.(*dns.TXT)
+		sent[txt.Hdr.Name] = strings.Join(txt.Txt, "")
+	}
+	listener := new(ecc.KeyPair)
+	listener.GenerateKey()
+	s := &P2P{Node: ram.New(nil, listener), Trusted: []string{key.GetPubKey().ToB64()}}
+	if err := s.authenticate(target, 1, sent); err != nil {
+		t.Fatal("signed advertisement was refused:", err)
+	}
+	if err := s.authenticate(target, 2, sent); err == nil {
+		t.Fatal("altered advertisement was accepted")
+	}
+}
+
+func Test_P2P_RequireSigned_Unsupported_1(t *testing.T) {
+	// rsa routing keys can not sign, a policy that needs signatures must not start with one
+	for _, s := range []*P2P{{RequireSigned: true}, {Trusted: []string{"key"}}} {
+		s.Node = ram.New(nil, new(rsa.KeyPair))
+		s.Transport = new(fakeTransport)
+		if err := s.RunPolicy(); err == nil {
+			s.Stop()
+			t.Fatalf("P2P started without a way to sign: %+v", s)
+		}
+	}
+	g := NewGossip(new(fakeTransport), ram.New(nil, new(rsa.KeyPair)))
+	g.Trusted = []string{"key"}
+	if err := g.RunPolicy(); err == nil {
+		g.Stop()
+		t.Fatal("Gossip started without a way to verify")
+	}
+}
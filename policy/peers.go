@@ -0,0 +1,63 @@
+package policy
+
+import (
+	"sync"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// PeerTable - last contact info for the peers polled by one policy instance
+type PeerTable struct {
+	// Persist - start each host from the Pickup cursors saved in the node's peer stats,
+	// so a restarted policy does not fetch the same messages again
+	Persist bool
+
+	mtx   sync.Mutex
+	peers map[string]*api.PeerInfo
+}
+
+// NewPeerTable - returns an empty PeerTable
+func NewPeerTable(persist bool) *PeerTable {
+	t := new(PeerTable)
+	t.Persist = persist
+	t.peers = make(map[string]*api.PeerInfo)
+	return t
+}
+
+// Get : Returns the PeerInfo for a host, which is created the first time a host is asked for
+func (t *PeerTable) Get(node api.Node, host string) *api.PeerInfo {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if peer, ok := t.peers[host]; ok {
+		return peer
+	}
+	peer := new(api.PeerInfo)
+	if t.Persist {
+		if stats, err := node.GetPeerStats(host); err != nil {
+			events.Warning(node, "could not load peer stats: "+err.Error())
+		} else if len(stats) > 0 {
+			peer.LastPollLocal, peer.LastPollRemote = stats[0].LastPollLocal, stats[0].LastPollRemote
+			peer.TotalBytesTX, peer.TotalBytesRX = stats[0].TotalBytesTX, stats[0].TotalBytesRX
+		}
+	}
+	t.peers[host] = peer
+	return peer
+}
+
+// lookup - returns the PeerInfo for a host, if it has been polled
+func (t *PeerTable) lookup(host string) (*api.PeerInfo, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	peer, ok := t.peers[host]
+	return peer, ok
+}
+
+// Forget : Drops the state of the given hosts, the next poll of a host starts over
+func (t *PeerTable) Forget(host ...string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, h := range host {
+		delete(t.peers, h)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/awgh/bencrypt/bc"
 	"github.com/awgh/ratnet"
 	"github.com/awgh/ratnet/api"
 	"github.com/awgh/ratnet/api/events"
@@ -18,9 +19,18 @@ type Poll struct {
 	// internal
 	wg        sync.WaitGroup
 	isRunning bool
+	err       error
+	done      chan struct{} // closed to abandon the polls in progress
+	// doneMtx - held to close done, and by polls that outlived their round while they report,
+	// so none reports after it was abandoned
+	doneMtx sync.RWMutex
 
 	// last poll times
-	lastPollLocal, lastPollRemote int64
+	peers   *PeerTable
+	backoff *backoff
+
+	inflightMtx sync.Mutex
+	inflight    map[string]bool // hosts with a poll still running, possibly past its timeout or a restart
 
 	Transport api.Transport
 	node      api.Node
@@ -29,6 +39,36 @@ type Poll struct {
 	jitter   int32
 
 	Group string
+	// Mode - whether to send messages to peers, fetch messages from them, or both
+	Mode PollMode
+	// Drain - keep exchanging bundles with a peer in one poll until its backlog is gone, off unless configured
+	Drain bool
+	// SessionBytes - stop draining a peer once this many bytes have moved, 0 for no limit
+	SessionBytes int64
+	// SessionTime - stop draining a peer after this many milliseconds, 0 for no limit
+	SessionTime int
+	// Channels - only fetch messages for these channels from peers, direct messages are always fetched
+	Channels []string
+	// OwnChannels - also fetch messages for every channel the node has a key for
+	OwnChannels bool
+	// PersistPeers - resume from the Pickup cursors saved by the node when the policy restarts
+	PersistPeers bool
+
+	// BackoffBase - milliseconds to wait before retrying a peer after its first failure,
+	// doubled for each further failure in a row up to BackoffMax, 0 (the default) to retry on every round
+	BackoffBase int
+	// BackoffMax - the longest wait between retries of a failing peer, in milliseconds, 0 for no limit
+	BackoffMax int
+	// FailureThreshold - failures in a row after which a peer is disabled for Cooldown,
+	// 0 (the default) to never disable it
+	FailureThreshold int
+	// Cooldown - milliseconds a disabled peer is left alone before it is tried again
+	Cooldown int
+
+	// Concurrency - how many peers are polled at the same time
+	Concurrency int
+	// PeerTimeout - milliseconds after which a poll counts as failed, 0 to wait for the transport
+	PeerTimeout int
 }
 
 func init() {
@@ -41,7 +81,48 @@ func NewPollFromMap(transport api.Transport, node api.Node,
 	interval := int(t["Interval"].(float64))
 	jitter := int(t["Jitter"].(float64))
 	group := string(t["Group"].(string))
-	return NewPoll(transport, node, interval, jitter, group)
+	p := NewPoll(transport, node, interval, jitter, group)
+	if name, ok := t["Mode"].(string); ok {
+		if mode, err := ParsePollMode(name); err == nil {
+			p.Mode = mode
+		} else {
+			events.Warning(node, err.Error()+", polling in both directions")
+		}
+	}
+	if persist, ok := t["PersistPeers"].(bool); ok {
+		p.PersistPeers = persist
+	}
+	if channels, ok := t["Channels"].([]interface{}); ok {
+		for _, c := range channels {
+			if name, ok := c.(string); ok {
+				p.Channels = append(p.Channels, name)
+			}
+		}
+	}
+	if own, ok := t["OwnChannels"].(bool); ok {
+		p.OwnChannels = own
+	}
+	if drain, ok := t["Drain"].(bool); ok {
+		p.Drain = drain
+	}
+	if n, ok := t["SessionBytes"].(float64); ok && n >= 0 {
+		p.SessionBytes = int64(n)
+	}
+	settings := map[string]*int{
+		"BackoffBase":      &p.BackoffBase,
+		"BackoffMax":       &p.BackoffMax,
+		"FailureThreshold": &p.FailureThreshold,
+		"Cooldown":         &p.Cooldown,
+		"Concurrency":      &p.Concurrency,
+		"PeerTimeout":      &p.PeerTimeout,
+		"SessionTime":      &p.SessionTime,
+	}
+	for k, v := range settings {
+		if f, ok := t[k].(float64); ok && f >= 0 {
+			*v = int(f)
+		}
+	}
+	return p
 }
 
 // NewPoll : Returns a new instance of a Poll Connection Policy
@@ -56,6 +137,12 @@ func NewPoll(transport api.Transport, node api.Node, interval, jitter int, group
 	p.node = node
 	p.interval = int32(interval)
 	p.jitter = int32(jitter)
+	p.BackoffMax = DefaultBackoffMax
+	p.Cooldown = DefaultCooldown
+	p.Concurrency = DefaultConcurrency
+	p.PeerTimeout = DefaultPeerTimeout
+	p.SessionTime = DefaultSessionTime
+	p.inflight = make(map[string]bool)
 
 	return p
 }
@@ -83,11 +170,24 @@ func (p *Poll) SetJitter(newJitter int) {
 // MarshalJSON : Create a serialied representation of the config of this policy
 func (p *Poll) MarshalJSON() (b []byte, e error) {
 	return json.Marshal(map[string]interface{}{
-		"Policy":    "poll",
-		"Transport": p.Transport,
-		"Interval":  p.GetInterval(),
-		"Jitter":    p.GetJitter(),
-		"Group":     p.Group})
+		"Policy":           "poll",
+		"Transport":        p.Transport,
+		"Interval":         p.GetInterval(),
+		"Jitter":           p.GetJitter(),
+		"Group":            p.Group,
+		"Mode":             p.Mode.String(),
+		"PersistPeers":     p.PersistPeers,
+		"BackoffBase":      p.BackoffBase,
+		"BackoffMax":       p.BackoffMax,
+		"FailureThreshold": p.FailureThreshold,
+		"Cooldown":         p.Cooldown,
+		"Concurrency":      p.Concurrency,
+		"PeerTimeout":      p.PeerTimeout,
+		"Drain":            p.Drain,
+		"SessionBytes":     p.SessionBytes,
+		"SessionTime":      p.SessionTime,
+		"Channels":         p.Channels,
+		"OwnChannels":      p.OwnChannels})
 }
 
 // RunPolicy : Poll
@@ -95,59 +195,43 @@ func (p *Poll) RunPolicy() error {
 	if p.isRunning {
 		return errors.New("Policy is already running")
 	}
+	pubsrv, err := p.node.ID()
+	if err != nil {
+		p.err = errors.New("Couldn't get routing key in Poll.RunPolicy: " + err.Error())
+		return p.err
+	}
+	p.err = nil
 
-	p.lastPollLocal = 0
-	p.lastPollRemote = 0
+	p.reset()
+	p.isRunning = true
+	events.Emit(p.node, api.Info, api.PolicyStarted, api.PolicyEvent{Policy: "poll", Transport: p.Transport.Name()})
 
 	p.wg.Add(1)
 	go func() {
 		defer p.wg.Done()
-		if p.isRunning {
-			return
-		}
-		p.isRunning = true
-
-		pubsrv, err := p.node.ID()
-		if err != nil {
-			events.Critical(p.node, "Couldn't get routing key in Poll.RunPolicy:\n"+err.Error())
-		}
 
 		b := make([]byte, 1)
 		counter := 0
 		for {
-			// check if we should still be running
-			if !p.isRunning {
-				break
-			}
-
+			var sleep time.Duration
 			if interval := p.GetInterval(); interval > 0 {
 				delay := time.Duration(interval) * time.Millisecond
 				rand.Read(b)
-				var sleep time.Duration
 				jit := p.GetJitter()
 				if jit == 0 { // no jitter discount, no divide by zero
 					sleep = delay
 				} else { // discount a jitter amount within the given percentage
 					sleep = (time.Duration((float64(100-(int(b[0])%jit)) / 100) * float64(delay)))
 				}
-				time.Sleep(sleep) // update interval
-			}
-
-			// Get Server List for this Poll's assigned Group
-			peers, err := p.node.GetPeers(p.Group)
-			if err != nil {
-				events.Warning(p.node, "Poll.RunPolicy error in loop: ", err)
-				continue
 			}
-			for _, element := range peers {
-				if element.Enabled {
-					_, err := PollServer(p.Transport, p.node, element.URI, pubsrv)
-					if err != nil {
-						events.Warning(p.node, "pollServer error: ", err.Error())
-					}
-				}
+			select {
+			case <-p.done:
+				return
+			case <-time.After(sleep): // update interval
 			}
 
+			p.round(pubsrv, time.Time{})
+
 			if counter%500 == 0 {
 				p.node.FlushOutbox(300) // seconds to cache
 			}
@@ -158,11 +242,206 @@ func (p *Poll) RunPolicy() error {
 	return nil
 }
 
-// Stop : Stops this instance of Poll from running
+// reset - clears the peer state kept between rounds, for a fresh start of the policy
+func (p *Poll) reset() {
+	p.peers = NewPeerTable(p.PersistPeers)
+	p.done = make(chan struct{})
+	p.backoff = newBackoff(time.Duration(p.BackoffBase)*time.Millisecond, time.Duration(p.BackoffMax)*time.Millisecond,
+		p.FailureThreshold, time.Duration(p.Cooldown)*time.Millisecond)
+}
+
+// round - polls the peers of this Poll's assigned Group once, without starting exchanges after deadline unless it is zero
+func (p *Poll) round(pubsrv bc.PubKey, deadline time.Time) {
+	// Get Server List for this Poll's assigned Group
+	peers, err := p.node.GetPeers(p.Group)
+	if err != nil {
+		events.Warning(p.node, "Poll.RunPolicy error in loop: ", err)
+		return
+	}
+	p.pollAll(peers, pubsrv, p.pickupChannels(), deadline)
+}
+
+// pickupChannels - returns the channels to fetch from peers, nil for all of them,
+// direct messages are always included when the list is restricted
+func (p *Poll) pickupChannels() []string {
+	if len(p.Channels) == 0 && !p.OwnChannels {
+		return nil
+	}
+	names := []string{""}
+	seen := map[string]bool{"": true}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range p.Channels {
+		add(name)
+	}
+	if p.OwnChannels {
+		channels, err := p.node.GetChannels()
+		if err != nil {
+			events.Warning(p.node, "Poll could not list the node's channels: "+err.Error())
+		}
+		for _, c := range channels {
+			add(c.Name)
+		}
+	}
+	return names
+}
+
+// pollAll - polls the enabled peers that are not backed off, Concurrency at a time,
+// and returns when each has finished, timed out, or run into the deadline
+func (p *Poll) pollAll(peers []api.Peer, pubsrv bc.PubKey, channels []string, deadline time.Time) {
+	workers := p.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var round sync.WaitGroup
+	for _, element := range peers {
+		if !element.Enabled || !p.backoff.ready(element.URI, time.Now()) {
+			continue
+		}
+		select {
+		case <-p.done: // stopping, do not start any more polls
+			round.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) { // passed while waiting for a worker
+			<-sem
+			break
+		}
+		round.Add(1)
+		go func(host string) {
+			defer func() {
+				<-sem
+				round.Done()
+			}()
+			p.poll(host, pubsrv, channels, deadline)
+		}(element.URI)
+	}
+	round.Wait()
+}
+
+// poll - polls one peer, backing off from it if it fails or does not finish within PeerTimeout,
+// and stops waiting for it at the deadline, which is not a failure of the peer
+func (p *Poll) poll(host string, pubsrv bc.PubKey, channels []string, deadline time.Time) {
+	p.inflightMtx.Lock()
+	if p.inflight[host] { // an earlier poll timed out but is still running, do not start a second one
+		p.inflightMtx.Unlock()
+		return
+	}
+	p.inflight[host] = true
+	p.inflightMtx.Unlock()
+
+	opts := PollOptions{
+		Mode:     p.Mode,
+		Drain:    p.Drain,
+		MaxBytes: p.SessionBytes,
+		MaxTime:  time.Duration(p.SessionTime) * time.Millisecond,
+		Channels: channels,
+	}
+	var timeout <-chan time.Time
+	if p.PeerTimeout > 0 {
+		limit := time.Duration(p.PeerTimeout) * time.Millisecond
+		timer := time.NewTimer(limit)
+		defer timer.Stop()
+		timeout = timer.C
+		opts.Deadline = time.Now().Add(limit)
+	}
+	var cutoff <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		cutoff = timer.C
+		if opts.Deadline.IsZero() || deadline.Before(opts.Deadline) {
+			opts.Deadline = deadline
+		}
+	}
+
+	type result struct {
+		happy bool
+		err   error
+	}
+	done := make(chan result, 1)
+	// the exchange can outlive this round and a restart of the policy, so it keeps the state of this run
+	peers, stopped := p.peers, p.done
+	report := newPollReport(peers, host)
+	var reported int32 // the first of the poll and its timeout to finish reports the outcome, the other one does not
+	go func() {
+		happy, err := pollServer(p.Transport, p.node, peers, host, pubsrv, opts)
+		p.inflightMtx.Lock()
+		delete(p.inflight, host)
+		p.inflightMtx.Unlock()
+		p.doneMtx.RLock()
+		select {
+		case <-stopped: // abandoned, the policy has already reported that it stopped
+		default:
+			if atomic.CompareAndSwapInt32(&reported, 0, 1) {
+				peer, _ := peers.lookup(host)
+				report.send(p.node, peer, err)
+			}
+		}
+		p.doneMtx.RUnlock()
+		done <- result{happy, err}
+	}()
+
+	var happy bool
+	var err error
+	select {
+	case r := <-done:
+		happy, err = r.happy, r.err
+	case <-timeout:
+		if atomic.CompareAndSwapInt32(&reported, 0, 1) {
+			err = errors.New("poll timed out")
+			report.send(p.node, nil, err)
+		} else { // it finished just now
+			r := <-done
+			happy, err = r.happy, r.err
+		}
+	case <-p.done: // stopping, the poll is abandoned without a report
+		return
+	case <-cutoff: // out of time, as when stopping
+		return
+	}
+	if happy {
+		if p.backoff.success(host) {
+			events.Info(p.node, "peer recovered, polling it again: ", host)
+		}
+		return
+	}
+	if err != nil {
+		events.Warning(p.node, "pollServer error: ", err.Error())
+	}
+	if p.backoff.failure(host, time.Now()) {
+		events.Warning(p.node, "peer failed ", p.FailureThreshold, " times in a row, disabled for ", p.Cooldown, "ms: ", host)
+	}
+}
+
+// Stop : Stops this instance of Poll from running, the transport is left alone since
+// Poll only makes outgoing calls with it and it may be shared with other policies
 func (p *Poll) Stop() {
+	if !p.isRunning {
+		return
+	}
 	p.isRunning = false
+	p.cancel()
 	p.wg.Wait()
-	p.Transport.Stop()
+	events.Emit(p.node, api.Info, api.PolicyStopped, api.PolicyEvent{Policy: "poll", Transport: p.Transport.Name()})
+}
+
+// cancel - stops waiting for the polls in progress, they finish without reporting their outcome
+func (p *Poll) cancel() {
+	p.doneMtx.Lock()
+	close(p.done)
+	p.doneMtx.Unlock()
+}
+
+// Status : Returns whether this policy is running
+func (p *Poll) Status() api.PolicyStatus {
+	return policyStatus("poll", p.isRunning, p.err)
 }
 
 // GetTransport : Returns the transports associated with this policy
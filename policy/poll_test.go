@@ -0,0 +1,426 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+	"github.com/awgh/ratnet/nodes/ram"
+)
+
+// fakeTransport - makes the calls of a poll directly on a remote node, and records them
+type fakeTransport struct {
+	remote api.Node
+	delay  time.Duration
+	block  chan struct{} // if set, calls wait until it is closed
+	limit  int64
+	fixed  *api.Bundle // if set, returned by every Pickup
+
+	mtx       sync.Mutex
+	calls     []string   // "host method" of each call
+	channels  [][]string // channel names passed to each Pickup
+	active    int
+	maxActive int
+}
+
+func (t *fakeTransport) RPC(host string, method string, args ...interface{}) (interface{}, error) {
+	t.mtx.Lock()
+	t.calls = append(t.calls, host+" "+method)
+	t.active++
+	if t.active > t.maxActive {
+		t.maxActive = t.active
+	}
+	t.mtx.Unlock()
+	defer func() {
+		t.mtx.Lock()
+		t.active--
+		t.mtx.Unlock()
+	}()
+
+	if t.block != nil {
+		<-t.block
+	}
+	time.Sleep(t.delay)
+	switch method {
+	case "ID":
+		id, err := t.remote.ID()
+		return id, err
+	case "Pickup":
+		if t.fixed != nil {
+			return *t.fixed, nil
+		}
+		var channels []string
+		for _, c := range args[2:] {
+			channels = append(channels, c.(string))
+		}
+		t.mtx.Lock()
+		t.channels = append(t.channels, channels)
+		t.mtx.Unlock()
+		return t.remote.Pickup(args[0].(bc.PubKey), args[1].(int64), t.ByteLimit(), channels...)
+	case "Dropoff":
+		return nil, t.remote.Dropoff(args[0].(api.Bundle))
+	case "GetPeerList":
+		return t.remote.GetPeerList()
+	}
+	return nil, errors.New("unknown method " + method)
+}
+
+func (t *fakeTransport) count(method string) int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	n := 0
+	for _, c := range t.calls {
+		if len(c) >= len(method) && c[len(c)-len(method):] == method {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *fakeTransport) Listen(listen string, adminMode bool) {}
+func (t *fakeTransport) Name() string                         { return "fake" }
+func (t *fakeTransport) Stop()                                {}
+func (t *fakeTransport) MarshalJSON() ([]byte, error)         { return []byte(`{"Transport":"fake"}`), nil }
+func (t *fakeTransport) ByteLimit() int64                     { return t.limit }
+func (t *fakeTransport) SetByteLimit(limit int64)             { t.limit = limit }
+
+func newTestPoll(transport *fakeTransport, peers int) (*Poll, bc.PubKey) {
+	node := ram.New(nil, nil)
+	transport.remote = ram.New(nil, nil)
+	if transport.limit == 0 {
+		transport.limit = 8 * 1024
+	}
+	for i := 0; i < peers; i++ {
+		host := "peer" + strconv.Itoa(i)
+		node.AddPeer(host, true, host)
+	}
+	p := NewPoll(transport, node, 0, 0)
+	p.reset()
+	pubsrv, _ := node.ID()
+	return p, pubsrv
+}
+
+func inflight(p *Poll, host string) bool {
+	p.inflightMtx.Lock()
+	defer p.inflightMtx.Unlock()
+	return p.inflight[host]
+}
+
+func Test_Poll_Concurrency_1(t *testing.T) {
+	transport := &fakeTransport{delay: 20 * time.Millisecond}
+	p, pubsrv := newTestPoll(transport, 6)
+	p.Concurrency = 2
+	p.round(pubsrv, time.Time{})
+
+	if transport.maxActive != 2 {
+		t.Fatalf("%d calls at once with a concurrency of 2", transport.maxActive)
+	}
+	if n := transport.count("Pickup"); n != 6 {
+		t.Fatalf("%d of 6 peers were polled", n)
+	}
+}
+
+func Test_Poll_Timeout_1(t *testing.T) {
+	transport := &fakeTransport{block: make(chan struct{})}
+	p, pubsrv := newTestPoll(transport, 1)
+	p.PeerTimeout = 20
+	sub := events.Subscribe(p.node, 0, api.PeerPolled, api.PeerFailed)
+	defer sub.Close()
+
+	p.poll("peer0", pubsrv, nil, time.Time{})
+	select {
+	case e := <-sub.Events():
+		if e.Type != api.PeerFailed {
+			t.Fatalf("expected PeerFailed on timeout, got %v", e.Type)
+		}
+	default:
+		t.Fatal("no event on timeout")
+	}
+
+	// the poll still running is not started again, and is not reported when it finally ends
+	p.poll("peer0", pubsrv, nil, time.Time{})
+	close(transport.block)
+	for i := 0; i < 100 && inflight(p, "peer0"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if inflight(p, "peer0") {
+		t.Fatal("timed out poll never finished")
+	}
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case e := <-sub.Events():
+		t.Fatalf("timed out poll was reported again: %v %+v", e.Type, e.Payload)
+	default:
+	}
+	if n := transport.count("ID"); n != 1 {
+		t.Fatalf("%d polls of a peer that timed out, expected 1", n)
+	}
+	stats, _ := p.node.GetPeerStats("peer0")
+	if len(stats) != 1 || stats[0].Failures != 1 || stats[0].Polls != 0 {
+		t.Fatalf("expected one failure in the stats: %+v", stats)
+	}
+}
+
+func Test_Poll_Stop_1(t *testing.T) {
+	transport := &fakeTransport{block: make(chan struct{})}
+	defer close(transport.block)
+	p, _ := newTestPoll(transport, 1)
+	p.PeerTimeout = 60000
+
+	// Stop does not wait out a poll in progress
+	if err := p.RunPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && transport.count("ID") == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	start := time.Now()
+	p.Stop()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Stop waited %v for a blocked poll", d)
+	}
+
+	// or the interval
+	p.SetInterval(60000)
+	if err := p.RunPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	p.Stop()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Stop waited %v for the interval", d)
+	}
+}
+
+func Test_Poll_Restart_1(t *testing.T) {
+	transport := &fakeTransport{block: make(chan struct{})}
+	p, _ := newTestPoll(transport, 1)
+	p.SetInterval(10)
+	sub := events.Subscribe(p.node, 0, api.PeerPolled, api.PeerFailed, api.PolicyStopped)
+	defer sub.Close()
+
+	if err := p.RunPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && transport.count("ID") == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.Stop()
+	// the abandoned poll is still running, the new run must not poll the same peer next to it
+	if err := p.RunPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := transport.count("ID"); n != 1 {
+		t.Fatalf("%d polls of a peer with a poll still running, expected 1", n)
+	}
+	p.Stop()
+	close(transport.block)
+	for i := 0; i < 100 && inflight(p, "peer0"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	for stops := 0; stops < 2; {
+		select {
+		case e := <-sub.Events():
+			if e.Type != api.PolicyStopped {
+				t.Fatalf("abandoned poll was reported: %v %+v", e.Type, e.Payload)
+			}
+			stops++
+		default:
+			t.Fatal("missing PolicyStopped events, got", stops)
+		}
+	}
+	select {
+	case e := <-sub.Events():
+		t.Fatalf("abandoned poll was reported after the policy stopped: %v %+v", e.Type, e.Payload)
+	default:
+	}
+}
+
+func Test_PollServer_Modes_1(t *testing.T) {
+	for _, mode := range []PollMode{PollBoth, PollPush, PollPull} {
+		transport := &fakeTransport{}
+		p, pubsrv := newTestPoll(transport, 1)
+		local, remote := p.node, transport.remote
+		localCID, _ := local.CID()
+		remoteCID, _ := remote.CID()
+		if err := local.SendMsg(api.Msg{Content: bytes.NewBufferString(strings.Repeat("to the remote node ", 4)), PubKey: remoteCID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.SendMsg(api.Msg{Content: bytes.NewBufferString(strings.Repeat("to the local node ", 4)), PubKey: localCID}); err != nil {
+			t.Fatal(err)
+		}
+
+		peers := NewPeerTable(false)
+		if happy, err := PollServerWith(transport, local, peers, "peer0", pubsrv, PollOptions{Mode: mode}); !happy || err != nil {
+			t.Fatalf("%s: poll failed: %v", mode, err)
+		}
+		peer, _ := peers.lookup("peer0")
+		push, pull := mode != PollPull, mode != PollPush
+		if sent := transport.count("Dropoff") > 0; sent != push || (peer.TotalBytesTX > 0) != push {
+			t.Fatalf("%s: sent %d bytes to the peer in %d calls", mode, peer.TotalBytesTX, transport.count("Dropoff"))
+		}
+		if fetched := transport.count("Pickup") > 0; fetched != pull || (peer.TotalBytesRX > 0) != pull {
+			t.Fatalf("%s: fetched %d bytes from the peer in %d calls", mode, peer.TotalBytesRX, transport.count("Pickup"))
+		}
+	}
+}
+
+func Test_PollServer_Shared_1(t *testing.T) {
+	transport := &fakeTransport{}
+	p, pubsrv := newTestPoll(transport, 1)
+	if happy, err := PollServer(transport, p.node, "shared", pubsrv); !happy || err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	if _, ok := sharedPeers.lookup("shared"); !ok {
+		t.Fatal("PollServer did not keep the state of the host")
+	}
+}
+
+// queue - sends n distinct direct messages from one node to another
+func queue(t *testing.T, from, to api.Node, n int) {
+	cid, _ := to.CID()
+	for i := 0; i < n; i++ {
+		content := strings.Repeat("message "+strconv.Itoa(i)+" ", 8)
+		if err := from.SendMsg(api.Msg{Content: bytes.NewBufferString(content), PubKey: cid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_PollServer_Drain_1(t *testing.T) {
+	if NewPoll(&fakeTransport{}, nil, 0, 0).Drain {
+		t.Fatal("Poll drains by default")
+	}
+
+	// without limits, rounds go on until a round moves nothing
+	transport := &fakeTransport{limit: 400}
+	p, pubsrv := newTestPoll(transport, 1)
+	queue(t, p.node, transport.remote, 12)
+	all, _ := p.node.Pickup(nil, 0, 0)
+	peers := NewPeerTable(false)
+	if happy, err := PollServerWith(transport, p.node, peers, "peer0", pubsrv, PollOptions{Mode: PollPush, Drain: true}); !happy {
+		t.Fatal(err)
+	}
+	peer, _ := peers.lookup("peer0")
+	if rounds := transport.count("Dropoff"); rounds < 3 {
+		t.Fatalf("backlog moved in %d rounds, the byte limit should need more", rounds)
+	}
+	if peer.TotalBytesTX < int64(len(all.Data)) {
+		t.Fatalf("drained %d of %d bytes", peer.TotalBytesTX, len(all.Data))
+	}
+
+	// without Drain, only one round
+	transport = &fakeTransport{limit: 400}
+	p, pubsrv = newTestPoll(transport, 1)
+	queue(t, p.node, transport.remote, 12)
+	PollServerWith(transport, p.node, NewPeerTable(false), "peer0", pubsrv, PollOptions{Mode: PollPush})
+	if rounds := transport.count("Dropoff"); rounds != 1 {
+		t.Fatalf("%d rounds without Drain", rounds)
+	}
+}
+
+func Test_PollServer_Drain_Limits_1(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		delay time.Duration
+		opts  PollOptions
+	}{
+		{"MaxBytes", 0, PollOptions{Mode: PollPush, Drain: true, MaxBytes: 1}},
+		{"MaxTime", 20 * time.Millisecond, PollOptions{Mode: PollPush, Drain: true, MaxTime: time.Millisecond}},
+		{"Deadline", 20 * time.Millisecond, PollOptions{Mode: PollPush, Drain: true, Deadline: time.Now()}},
+	} {
+		transport := &fakeTransport{limit: 400, delay: c.delay}
+		p, pubsrv := newTestPoll(transport, 1)
+		queue(t, p.node, transport.remote, 12)
+		if happy, err := PollServerWith(transport, p.node, NewPeerTable(false), "peer0", pubsrv, c.opts); !happy {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if rounds := transport.count("Dropoff"); rounds != 1 {
+			t.Fatalf("%s: %d rounds, the limit should stop after the first", c.name, rounds)
+		}
+	}
+}
+
+func Test_PollServer_Drain_Stall_1(t *testing.T) {
+	// a peer that keeps returning the same bundle does not move the cursors
+	transport := &fakeTransport{limit: 400}
+	p, pubsrv := newTestPoll(transport, 1)
+	queue(t, transport.remote, p.node, 2)
+	bundle, err := transport.remote.Pickup(nil, 0, 400)
+	if err != nil || len(bundle.Data) == 0 {
+		t.Fatal("nothing to pick up", err)
+	}
+	transport.fixed = &bundle
+	if happy, err := PollServerWith(transport, p.node, NewPeerTable(false), "peer0", pubsrv, PollOptions{Mode: PollPull, Drain: true}); !happy {
+		t.Fatal(err)
+	}
+	// the first round moves the cursor, the next one is allowed to catch up, the third gives up
+	if rounds := transport.count("Pickup"); rounds != 3 {
+		t.Fatalf("%d rounds with a stuck peer", rounds)
+	}
+}
+
+const testChannelKey = "Tcksa18txiwMEocq7NXdeMwz6PPBD+nxCjb/WCtxq1+dln3M3IaOmg+YfTIbBpk+jIbZZZiT+4CoeFzaJGEWmg=="
+
+func Test_Poll_Channels_1(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		channels []string
+		own      []string
+		want     []string // channel names passed to the remote Pickup
+	}{
+		{"all", nil, nil, nil},
+		{"configured", []string{"a"}, nil, []string{"", "a"}},
+		{"own", nil, []string{"b"}, []string{"", "b"}},
+		{"both", []string{"a", "b"}, []string{"b"}, []string{"", "a", "b"}},
+	} {
+		transport := &fakeTransport{}
+		p, pubsrv := newTestPoll(transport, 1)
+		p.Mode = PollPull
+		p.Channels = c.channels
+		for _, name := range c.own {
+			if err := p.node.AddChannel(name, testChannelKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p.OwnChannels = len(c.own) > 0
+		for _, name := range []string{"a", "b"} {
+			if err := transport.remote.AddChannel(name, testChannelKey); err != nil {
+				t.Fatal(err)
+			}
+			content := strings.Repeat("flooded on channel "+name+" ", 4)
+			if err := transport.remote.SendMsg(api.Msg{Name: name, IsChan: true, Content: bytes.NewBufferString(content)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		p.round(pubsrv, time.Time{})
+		if len(transport.channels) != 1 || strings.Join(transport.channels[0], ",") != strings.Join(c.want, ",") {
+			t.Fatalf("%s: remote Pickup for channels %q, expected %q", c.name, transport.channels, c.want)
+		}
+		// only the subscribed channels came back, and are relayed by the local node
+		for _, name := range []string{"a", "b"} {
+			relayed, err := p.node.Pickup(nil, 0, 0, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wanted := c.want == nil
+			for _, w := range c.want {
+				wanted = wanted || w == name
+			}
+			if (len(relayed.Data) > 0) != wanted {
+				t.Fatalf("%s: channel %s picked up: %v, expected %v", c.name, name, len(relayed.Data) > 0, wanted)
+			}
+		}
+	}
+}
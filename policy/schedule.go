@@ -0,0 +1,286 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/awgh/bencrypt/bc"
+	"github.com/awgh/ratnet"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// ScheduleTick : how often a Schedule policy checks its windows
+const ScheduleTick = time.Second
+
+// Schedule : defines a Connection Policy which polls each peer group only inside its transmit windows,
+// outside them the group is quiet and messages for it wait in the outbox
+type Schedule struct {
+	// internal
+	wg        sync.WaitGroup
+	isRunning bool
+	err       error
+	done      chan struct{}
+
+	Transport api.Transport
+	node      api.Node
+
+	// Location - the time zone windows are in, local time if nil
+	Location *time.Location
+	// FlushAge - seconds outbox messages are kept for while waiting for a window, 0 (the default) to keep them until they expire,
+	// set it longer than the longest gap between windows or queued messages are flushed before they can be sent
+	FlushAge int
+	// Polls - the peer groups and their windows
+	Polls []*ScheduledPoll
+}
+
+// ScheduledPoll : a Poll policy and the windows it may transmit in, it is open when any of them is
+type ScheduledPoll struct {
+	// Poll - polls its Group every Interval milliseconds while a window is open, it is never run on its own
+	Poll *Poll
+	// Daily - windows repeating every day or on some days of the week, as in "08:00-12:00" or "Mon-Fri 22:00-02:00",
+	// one ending when it starts, as in "08:00-08:00", lasts 24 hours
+	Daily []string
+	// Cron - five field cron expressions, each match opening a window of Duration
+	Cron []string
+	// Duration - milliseconds a window opened by Cron lasts
+	Duration int
+
+	daily []*dailyWindow
+	cron  []*cronSpec
+	open  bool
+	last  time.Time
+	busy  int32 // a round is running, accessed atomically
+}
+
+func init() {
+	ratnet.Policies["schedule"] = NewScheduleFromMap // register this module by name (for deserialization support)
+}
+
+// NewScheduleFromMap : Makes a new instance of this policy module from a map of arguments (for deserialization support)
+func NewScheduleFromMap(transport api.Transport, node api.Node,
+	t map[string]interface{}) api.Policy {
+	s := NewSchedule(transport, node)
+	if name, ok := t["Location"].(string); ok {
+		if loc, err := time.LoadLocation(name); err == nil {
+			s.Location = loc
+		} else {
+			events.Warning(node, "Schedule could not load location "+name+", using local time: "+err.Error())
+		}
+	}
+	if age, ok := t["FlushAge"].(float64); ok && age >= 0 {
+		s.FlushAge = int(age)
+	}
+	polls, _ := t["Polls"].([]interface{})
+	for _, v := range polls {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		pm, ok := m["Poll"].(map[string]interface{})
+		if !ok {
+			events.Warning(node, "Schedule entry has no Poll, skipping it")
+			continue
+		}
+		poll := NewPollFromMap(transport, node, pm).(*Poll)
+		duration, _ := m["Duration"].(float64)
+		sp, err := NewScheduledPoll(poll, stringList(m["Daily"]), stringList(m["Cron"]), int(duration))
+		if err != nil {
+			events.Warning(node, "Schedule entry for group \""+poll.Group+"\" skipped: "+err.Error())
+			continue
+		}
+		s.Polls = append(s.Polls, sp)
+	}
+	return s
+}
+
+// stringList - reads a list of strings from a config map
+func stringList(v interface{}) []string {
+	var list []string
+	items, _ := v.([]interface{})
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// NewSchedule : Returns a new instance of a Schedule Connection Policy, add peer groups to Polls
+func NewSchedule(transport api.Transport, node api.Node) *Schedule {
+	s := new(Schedule)
+	s.Transport = transport
+	s.node = node
+	return s
+}
+
+// NewScheduledPoll : Returns the windows for a Poll, an error if any of them does not parse
+func NewScheduledPoll(poll *Poll, daily, cron []string, duration int) (*ScheduledPoll, error) {
+	sp := &ScheduledPoll{Poll: poll, Daily: daily, Cron: cron, Duration: duration}
+	for _, d := range daily {
+		w, err := parseDailyWindow(d)
+		if err != nil {
+			return nil, err
+		}
+		sp.daily = append(sp.daily, w)
+	}
+	for _, c := range cron {
+		spec, err := parseCron(c)
+		if err != nil {
+			return nil, err
+		}
+		sp.cron = append(sp.cron, spec)
+	}
+	if len(sp.cron) > 0 && duration <= 0 {
+		return nil, errors.New("Cron windows need a Duration")
+	}
+	return sp, nil
+}
+
+// Open : Returns whether t falls in one of the windows
+func (sp *ScheduledPoll) Open(t time.Time) bool {
+	_, open := sp.closes(t)
+	return open
+}
+
+// closes - returns when the last to close of the windows t falls in closes, false if it is in none of them
+func (sp *ScheduledPoll) closes(t time.Time) (time.Time, bool) {
+	var end time.Time
+	open := false
+	for _, w := range sp.daily {
+		if c, ok := w.closes(t); ok && c.After(end) {
+			end, open = c, true
+		}
+	}
+	// only a match since the earliest window that could still be open counts
+	duration := time.Duration(sp.Duration) * time.Millisecond
+	for _, c := range sp.cron {
+		if m, ok := c.last(t, t.Add(-duration)); ok && m.Add(duration).After(end) {
+			end, open = m.Add(duration), true
+		}
+	}
+	return end, open
+}
+
+// MarshalJSON : Create a serialied representation of the windows and the Poll policy
+func (sp *ScheduledPoll) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Poll":     sp.Poll,
+		"Daily":    sp.Daily,
+		"Cron":     sp.Cron,
+		"Duration": sp.Duration})
+}
+
+// MarshalJSON : Create a serialied representation of the config of this policy
+func (s *Schedule) MarshalJSON() (b []byte, e error) {
+	location := ""
+	if s.Location != nil {
+		location = s.Location.String()
+	}
+	return json.Marshal(map[string]interface{}{
+		"Policy":    "schedule",
+		"Transport": s.Transport,
+		"Location":  location,
+		"FlushAge":  s.FlushAge,
+		"Polls":     s.Polls})
+}
+
+// RunPolicy : Schedule
+func (s *Schedule) RunPolicy() error {
+	if s.isRunning {
+		return errors.New("Policy is already running")
+	}
+	pubsrv, err := s.node.ID()
+	if err != nil {
+		s.err = err
+		return err
+	}
+	s.err = nil
+	for _, sp := range s.Polls {
+		sp.Poll.reset()
+		sp.open = false
+	}
+	s.isRunning = true
+	s.done = make(chan struct{})
+	events.Emit(s.node, api.Info, api.PolicyStarted, api.PolicyEvent{Policy: "schedule", Transport: s.Transport.Name()})
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(ScheduleTick)
+		defer ticker.Stop()
+		lastFlush := time.Now()
+		for {
+			select {
+			case <-s.done:
+				return
+			case now := <-ticker.C:
+				if s.Location != nil {
+					now = now.In(s.Location)
+				}
+				for _, sp := range s.Polls {
+					s.tick(sp, now, pubsrv)
+				}
+				if s.FlushAge > 0 && now.Sub(lastFlush) >= time.Hour {
+					s.node.FlushOutbox(int64(s.FlushAge))
+					lastFlush = now
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// tick - starts a round of the Poll if its window is open and its Interval has passed since the last one,
+// the round stops exchanging messages when the window closes
+func (s *Schedule) tick(sp *ScheduledPoll, now time.Time, pubsrv bc.PubKey) {
+	closes, open := sp.closes(now)
+	if open != sp.open {
+		sp.open = open
+		if open {
+			events.Info(s.node, "Schedule window opened for group \"", sp.Poll.Group, "\"")
+		} else {
+			events.Info(s.node, "Schedule window closed for group \"", sp.Poll.Group, "\", messages wait in the outbox")
+		}
+	}
+	if !open || now.Sub(sp.last) < time.Duration(sp.Poll.GetInterval())*time.Millisecond {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&sp.busy, 0, 1) { // the last round outlasted the interval
+		return
+	}
+	sp.last = now
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer atomic.StoreInt32(&sp.busy, 0)
+		sp.Poll.round(pubsrv, closes)
+	}()
+}
+
+// Stop : Stops this instance of Schedule, waiting for rounds in progress to finish
+func (s *Schedule) Stop() {
+	if !s.isRunning {
+		return
+	}
+	s.isRunning = false
+	close(s.done)
+	for _, sp := range s.Polls {
+		sp.Poll.cancel()
+	}
+	s.wg.Wait()
+	events.Emit(s.node, api.Info, api.PolicyStopped, api.PolicyEvent{Policy: "schedule", Transport: s.Transport.Name()})
+}
+
+// Status : Returns whether this policy is running
+func (s *Schedule) Status() api.PolicyStatus {
+	return policyStatus("schedule", s.isRunning, s.err)
+}
+
+// GetTransport : Returns the transports associated with this policy
+func (s *Schedule) GetTransport() api.Transport {
+	return s.Transport
+}
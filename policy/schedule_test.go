@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+func Test_ScheduledPoll_Open_1(t *testing.T) {
+	for _, c := range []struct {
+		daily, cron []string
+		duration    time.Duration
+		t           time.Time
+		open        bool
+		closes      time.Time
+	}{
+		{nil, []string{"0 */6 * * *"}, 30 * time.Minute, at(0, 6, 10), true, at(0, 6, 30)},
+		{nil, []string{"0 */6 * * *"}, 30 * time.Minute, at(0, 6, 30), false, time.Time{}},
+		{nil, []string{"0 */6 * * *"}, 30 * time.Minute, at(0, 5, 59), false, time.Time{}},
+		// a window opened before midnight stays open after it
+		{nil, []string{"30 23 * * 1-5"}, time.Hour, at(1, 0, 15), true, at(1, 0, 30)},
+		{nil, []string{"30 23 * * 1-5"}, time.Hour, at(6, 0, 15), false, time.Time{}},
+		// and over the end of a month
+		{nil, []string{"0 0 1 * *"}, 48 * time.Hour, time.Date(2024, 2, 2, 12, 0, 0, 0, time.UTC), true,
+			time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)},
+		// a long Duration is found without stepping through every minute of it
+		{nil, []string{"0 0 29 2 *"}, 365 * 24 * time.Hour, time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), true,
+			time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC)},
+		// the later of overlapping windows
+		{[]string{"08:00-09:00"}, []string{"30 8 * * *"}, time.Hour, at(0, 8, 45), true, at(0, 9, 30)},
+		{[]string{"08:00-10:00"}, []string{"30 8 * * *"}, time.Hour, at(0, 8, 45), true, at(0, 10, 0)},
+		{[]string{"Sat,Sun 08:00-10:00"}, nil, 0, at(0, 8, 45), false, time.Time{}},
+	} {
+		sp, err := NewScheduledPoll(nil, c.daily, c.cron, int(c.duration/time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sp.Open(c.t) != c.open {
+			t.Fatalf("%v %v at %v: open is %v", c.daily, c.cron, c.t, !c.open)
+		}
+		if closes, _ := sp.closes(c.t); c.open && !closes.Equal(c.closes) {
+			t.Fatalf("%v %v at %v: closes at %v, expected %v", c.daily, c.cron, c.t, closes, c.closes)
+		}
+	}
+
+	if _, err := NewScheduledPoll(nil, nil, []string{"0 * * * *"}, 0); err == nil {
+		t.Fatal("cron window without a Duration accepted")
+	}
+}
+
+func Test_Schedule_FlushAge_1(t *testing.T) {
+	// windows can be further apart than any fixed age, so queued messages are kept unless asked
+	if age := NewSchedule(&fakeTransport{}, nil).FlushAge; age != 0 {
+		t.Fatal("schedule flushes the outbox by default, after", age)
+	}
+}
+
+func Test_Schedule_Deadline_1(t *testing.T) {
+	transport := &fakeTransport{block: make(chan struct{})}
+	defer close(transport.block)
+	p, pubsrv := newTestPoll(transport, 1)
+	p.PeerTimeout = 60000
+	sub := events.Subscribe(p.node, 0, api.PeerFailed)
+	defer sub.Close()
+
+	// a poll still running when the window closes is left behind, and not counted against the peer
+	start := time.Now()
+	p.round(pubsrv, start.Add(30*time.Millisecond))
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("round ran %v past the close of its window", d)
+	}
+	if transport.count("ID") != 1 {
+		t.Fatal("peer was not polled")
+	}
+	select {
+	case e := <-sub.Events():
+		t.Fatalf("closing window reported as a failure: %+v", e.Payload)
+	default:
+	}
+
+	// no poll starts once the window has closed
+	p.reset()
+	p.round(pubsrv, time.Now().Add(-time.Millisecond))
+	if transport.count("ID") != 1 {
+		t.Fatal("peer polled after the window closed")
+	}
+}
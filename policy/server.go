@@ -13,6 +13,8 @@ type Server struct {
 	Transport api.Transport
 	ListenURI string
 	AdminMode bool
+
+	isRunning bool
 }
 
 func init() {
@@ -49,6 +51,7 @@ func (s *Server) MarshalJSON() (b []byte, e error) {
 //
 func (s *Server) RunPolicy() error {
 	s.Transport.Listen(s.ListenURI, s.AdminMode)
+	s.isRunning = true
 	return nil
 }
 
@@ -56,6 +59,12 @@ func (s *Server) RunPolicy() error {
 //
 func (s *Server) Stop() {
 	s.Transport.Stop()
+	s.isRunning = false
+}
+
+// Status : Returns whether this policy is listening
+func (s *Server) Status() api.PolicyStatus {
+	return policyStatus("server", s.isRunning, nil)
 }
 
 // GetTransport : Returns the transports associated with this policy
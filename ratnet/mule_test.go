@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/awgh/bencrypt/ecc"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events/defaultlogger"
+	"github.com/awgh/ratnet/nodes/ram"
+	"github.com/awgh/ratnet/policy"
+	"github.com/awgh/ratnet/transports/udp"
+)
+
+const MuleURI = "127.0.0.1:20006"
+
+func Test_mule_1(t *testing.T) {
+	receivingNode := ram.New(new(ecc.KeyPair), new(ecc.KeyPair))
+	server := policy.NewServer(udp.New(receivingNode), MuleURI, false)
+	defaultlogger.StartDefaultLogger(receivingNode, api.Info)
+
+	muleNode := ram.New(new(ecc.KeyPair), new(ecc.KeyPair))
+	mule := policy.NewMule(udp.New(muleNode), muleNode, "")
+	mule.Interval = 100
+	muleNode.SetPolicy(mule)
+	muleNode.AddPeer("gone", true, "127.0.0.1:20099") // never reachable, the rotation has to move past it
+	muleNode.AddPeer("rc", true, MuleURI)
+	key, _ := receivingNode.CID()
+	muleNode.AddContact("rc", key.ToB64())
+	defaultlogger.StartDefaultLogger(muleNode, api.Info)
+
+	if err := muleNode.Start(); err != nil {
+		t.Fatalf("mule node failed to start: %v", err)
+	}
+	var received int32
+	go func() {
+		for {
+			<-receivingNode.Out()
+			atomic.AddInt32(&received, 1)
+		}
+	}()
+	send := func() {
+		if err := muleNode.Send("rc", []byte("carried by the mule")); err != nil {
+			t.Fatalf("error sending message to receiver: %v", err)
+		}
+	}
+	expect := func(n int32) {
+		time.Sleep(3 * time.Second)
+		if got := atomic.LoadInt32(&received); got != n {
+			t.Fatalf("expected receiving %d messages, got %d", n, got)
+		}
+	}
+
+	// the receiver is out of reach, messages wait in the mule's outbox
+	send()
+	send()
+	expect(0)
+
+	// it connects
+	if err := receivingNode.Start(); err != nil {
+		t.Fatalf("receiving node failed to start: %v", err)
+	}
+	if err := server.RunPolicy(); err != nil {
+		t.Fatalf("receiving server failed to start: %v", err)
+	}
+	expect(2)
+
+	// it disconnects, and comes back to a mule that was restarted in the meantime
+	server.Stop()
+	send()
+	mule.Stop()
+	if err := mule.RunPolicy(); err != nil {
+		t.Fatalf("mule failed to restart: %v", err)
+	}
+	expect(2)
+	if err := server.RunPolicy(); err != nil {
+		t.Fatalf("receiving server failed to restart: %v", err)
+	}
+	expect(3)
+
+	stats, err := muleNode.GetPeerStats(MuleURI)
+	if err != nil || len(stats) != 1 || stats[0].Polls == 0 || stats[0].LastPollLocal == 0 {
+		t.Fatalf("mule did not keep a cursor for the receiver: %+v %v", stats, err)
+	}
+}
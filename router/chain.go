@@ -0,0 +1,191 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/awgh/ratnet"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+// ChainRouter - runs each message through an ordered list of Filters,
+// then hands whatever survives to the next Router
+type ChainRouter struct {
+	// Internal
+	*ReplayCache
+	mtx sync.RWMutex
+
+	// Filters - stages run in order before the next Router sees the message
+	Filters []api.Filter
+	// Next - the next Router, which does the actual handling and forwarding
+	Next api.Router
+	// PersistReplay - Should the loop detection of the chain be saved by the node, so filters
+	// with side effects do not run again for messages seen before a restart
+	PersistReplay bool
+}
+
+func init() {
+	ratnet.Routers["chain"] = NewChainRouterFromMap // register this module by name (for deserialization support)
+}
+
+// NewChainRouterFromMap : Makes a new instance of this module from a map of arguments (for deserialization support)
+func NewChainRouterFromMap(r map[string]interface{}) (api.Router, error) {
+	var next api.Router
+	if n, ok := r["Next"]; ok && n != nil {
+		nm, ok := n.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("Router field Next must be an object")
+		}
+		var err error
+		if next, err = ratnet.NewRouterFromMap(nm); err != nil {
+			return nil, err
+		}
+	}
+	chain := NewChainRouter(next)
+	if p, ok := r["PersistReplay"]; ok {
+		if chain.PersistReplay, ok = p.(bool); !ok {
+			return nil, errors.New("Router field PersistReplay must be a bool")
+		}
+	}
+	if f, ok := r["Filters"]; ok && f != nil {
+		filters, ok := f.([]interface{})
+		if !ok {
+			return nil, errors.New("Router field Filters must be an array")
+		}
+		for _, f := range filters {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("Router field Filters must contain objects")
+			}
+			filter, err := ratnet.NewFilterFromMap(fm)
+			if err != nil {
+				return nil, err
+			}
+			chain.AddFilter(filter)
+		}
+	}
+	return chain, nil
+}
+
+// NewChainRouter - returns a new instance of ChainRouter wrapping the given Router,
+// if next is nil, a DefaultRouter is used
+func NewChainRouter(next api.Router, filters ...api.Filter) *ChainRouter {
+	r := new(ChainRouter)
+	if next == nil {
+		next = NewDefaultRouter()
+	}
+	r.Next = next
+	r.Filters = filters
+	r.ReplayCache = NewReplayCache(defaultReplayCapacity, defaultReplayTTL)
+	return r
+}
+
+// AddFilter : Appends a Filter to the end of the chain
+func (r *ChainRouter) AddFilter(filter api.Filter) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.Filters = append(r.Filters, filter)
+}
+
+// GetFilters : Returns the Filters in the order they are run
+func (r *ChainRouter) GetFilters() []api.Filter {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	filters := make([]api.Filter, len(r.Filters))
+	copy(filters, r.Filters)
+	return filters
+}
+
+// Patch : Redirect messages from one input to different outputs (passed to the next Router)
+func (r *ChainRouter) Patch(patch api.Patch) {
+	r.Next.Patch(patch)
+}
+
+// GetPatches : Returns an array with the mappings of incoming channels to destination channels
+func (r *ChainRouter) GetPatches() []api.Patch {
+	return r.Next.GetPatches()
+}
+
+// SetReplayStore : Reload previously seen nonces from the store and record new ones to it if PersistReplay is set,
+// otherwise passes the store to the next Router, since every message it sees has already passed the chain's check
+func (r *ChainRouter) SetReplayStore(store api.ReplayStore) error {
+	if r.PersistReplay {
+		return r.SetStore(store)
+	}
+	if next, ok := r.Next.(api.PersistentRouter); ok {
+		return next.SetReplayStore(store)
+	}
+	return nil
+}
+
+// Route - Runs the filter chain, then passes the message to the next Router
+func (r *ChainRouter) Route(node api.Node, message []byte) error {
+	msg, err := parseMessage(message)
+	if err != nil {
+		dropped(node, api.DropMalformed, msg)
+		return err
+	}
+	// loop detection has to happen here too, or filters with side effects would re-run on every copy
+	nonce := msg.Content.Bytes()[:nonceSize]
+	if r.SeenRecently(nonce) {
+		dropped(node, api.DropReplay, msg)
+		return nil
+	}
+	if err := r.Persist(nonce); err != nil {
+		events.Warning(node, "could not persist loop detection state: "+err.Error())
+	}
+	for _, filter := range r.GetFilters() {
+		var ok bool
+		msg, ok, err = filter.Filter(node, msg)
+		if err != nil {
+			return err
+		}
+		if !ok { // dropped by this stage
+			dropped(node, api.DropFiltered, msg)
+			return nil
+		}
+	}
+	return r.Next.Route(node, encodeMessage(msg))
+}
+
+// MarshalJSON : Create a serialized JSON blob out of the config of this router
+func (r *ChainRouter) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Router":        "chain",
+		"Filters":       r.GetFilters(),
+		"Next":          r.Next,
+		"PersistReplay": r.PersistReplay})
+}
+
+// encodeMessage - reassembles a routed message from its flags, hop limit, channel name, and encrypted content
+func encodeMessage(msg api.Msg) []byte {
+	flags := uint8(0)
+	if msg.IsChan {
+		flags |= api.ChannelFlag
+	}
+	if msg.Chunked {
+		flags |= api.ChunkedFlag
+	}
+	if msg.StreamHeader {
+		flags |= api.StreamHeaderFlag
+	}
+	if msg.HopLimit > 0 {
+		flags |= api.HopLimitFlag
+	}
+	if msg.Receipt {
+		flags |= api.ReceiptFlag
+	}
+	rxsum := []byte{flags} // prepend flags byte
+	if msg.HopLimit > 0 {
+		rxsum = append(rxsum, msg.HopLimit)
+	}
+	if msg.IsChan {
+		// prepend a uint16 of channel name length, big-endian
+		t := uint16(len(msg.Name))
+		rxsum = append(rxsum, byte(t>>8), byte(t&0xFF))
+		rxsum = append(rxsum, []byte(msg.Name)...)
+	}
+	return append(rxsum, msg.Content.Bytes()...)
+}
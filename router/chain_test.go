@@ -0,0 +1,230 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/awgh/ratnet"
+	"github.com/awgh/ratnet/api"
+)
+
+func Test_Chain_Filters_1(t *testing.T) {
+
+	drop := NewDropFilter("spam")
+	if _, ok, _ := drop.Filter(nil, api.Msg{Name: "spam", IsChan: true}); ok {
+		t.Fatal("DropFilter passed a message on a dropped channel")
+	}
+	if _, ok, _ := drop.Filter(nil, api.Msg{Name: "ham", IsChan: true}); !ok {
+		t.Fatal("DropFilter dropped a message on an allowed channel")
+	}
+
+	limit := NewRateLimitFilter(3, 60000)
+	passed := 0
+	for i := 0; i < 10; i++ {
+		if _, ok, _ := limit.Filter(nil, api.Msg{Name: "chat", IsChan: true}); ok {
+			passed++
+		}
+	}
+	if passed != 3 {
+		t.Fatal("RateLimitFilter passed", passed, "messages, expected 3")
+	}
+	if _, ok, _ := limit.Filter(nil, api.Msg{Name: "other", IsChan: true}); !ok {
+		t.Fatal("RateLimitFilter did not count channels separately")
+	}
+
+	transform := NewTransformFilter(map[string]string{"chat": "", "ops": "archive"})
+	msg, _, _ := transform.Filter(nil, api.Msg{Name: "chat", IsChan: true})
+	if msg.IsChan || msg.Name != "" {
+		t.Fatal("TransformFilter did not rename channel to content message")
+	}
+	msg, _, _ = transform.Filter(nil, api.Msg{Name: "ops", IsChan: true})
+	if !msg.IsChan || msg.Name != "archive" {
+		t.Fatal("TransformFilter did not rename channel")
+	}
+}
+
+func Test_Chain_RateLimit_Evict_1(t *testing.T) {
+
+	limit := NewRateLimitFilter(1, 10)
+	for i := 0; i < 100; i++ {
+		limit.Filter(nil, api.Msg{Name: strconv.Itoa(i), IsChan: true})
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, _ := limit.Filter(nil, api.Msg{Name: "0", IsChan: true}); !ok {
+		t.Fatal("RateLimitFilter did not start a new interval")
+	}
+	if len(limit.windows) != 1 {
+		t.Fatal("RateLimitFilter kept", len(limit.windows), "idle windows")
+	}
+}
+
+func Test_Chain_Message_RoundTrip_1(t *testing.T) {
+
+	content := bytes.Repeat([]byte{7}, nonceSize+16)
+	msg := api.Msg{Name: "chat", IsChan: true, Chunked: true, Content: bytes.NewBuffer(content)}
+	parsed, err := parseMessage(encodeMessage(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Name != msg.Name || !parsed.IsChan || !parsed.Chunked || parsed.StreamHeader {
+		t.Fatalf("message header did not round-trip: %+v", parsed)
+	}
+	if !bytes.Equal(parsed.Content.Bytes(), content) {
+		t.Fatal("message content did not round-trip")
+	}
+	msg.HopLimit = 3
+	parsed, err = parseMessage(encodeMessage(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.HopLimit != 3 || parsed.Name != msg.Name || !bytes.Equal(parsed.Content.Bytes(), content) {
+		t.Fatalf("message with hop limit did not round-trip: %+v", parsed)
+	}
+	if _, err := parseMessage(append([]byte{api.HopLimitFlag, 0}, content...)); err == nil {
+		t.Fatal("parseMessage accepted a zero hop limit")
+	}
+	if _, err := parseMessage([]byte{api.ChannelFlag, 0, 200, 1, 2}); err == nil {
+		t.Fatal("parseMessage accepted a truncated message")
+	}
+}
+
+func Test_Chain_JSON_RoundTrip_1(t *testing.T) {
+
+	chain := NewChainRouter(nil,
+		NewDropFilter("spam"),
+		NewRateLimitFilter(10, 1000),
+		NewTransformFilter(map[string]string{"a": "b"}),
+		NewTeeFilter("ops", "archive", "audit"))
+
+	b, err := json.Marshal(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ratnet.NewRouterFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, ok := r.(*ChainRouter)
+	if !ok {
+		t.Fatal("NewRouterFromMap did not return a ChainRouter")
+	}
+	b2, err := json.Marshal(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Fatalf("chain did not round-trip:\n%s\n%s", b, b2)
+	}
+	if len(restored.GetFilters()) != 4 {
+		t.Fatal("wrong number of filters restored:", len(restored.GetFilters()))
+	}
+	if _, ok := restored.Next.(*DefaultRouter); !ok {
+		t.Fatal("next router was not restored as a DefaultRouter")
+	}
+}
+
+// memStore - a ReplayStore kept in memory, standing in for the storage of a node
+type memStore struct{ seen []api.SeenNonce }
+
+func (s *memStore) AddSeenNonce(nonce []byte, timestamp int64) error {
+	s.seen = append(s.seen, api.SeenNonce{Nonce: nonce, Timestamp: timestamp})
+	return nil
+}
+
+func (s *memStore) GetSeenNonces(since int64) ([]api.SeenNonce, error) {
+	var seen []api.SeenNonce
+	for _, n := range s.seen {
+		if n.Timestamp > since {
+			seen = append(seen, n)
+		}
+	}
+	return seen, nil
+}
+
+func (s *memStore) FlushSeenNonces(before int64) error {
+	var seen []api.SeenNonce
+	for _, n := range s.seen {
+		if n.Timestamp >= before {
+			seen = append(seen, n)
+		}
+	}
+	s.seen = seen
+	return nil
+}
+
+func Test_Chain_PersistReplay_1(t *testing.T) {
+
+	store := new(memStore)
+	node := new(forwardNode)
+	message := encodeMessage(api.Msg{Name: "ops", IsChan: true, Content: bytes.NewBuffer(bytes.Repeat([]byte{9}, nonceSize+16))})
+	// the tee has a side effect, the drop keeps the message away from the next router
+	newChain := func() *ChainRouter {
+		chain := NewChainRouter(nil, NewTeeFilter("ops", "archive"), NewDropFilter("ops"))
+		chain.PersistReplay = true
+		if err := chain.SetReplayStore(store); err != nil {
+			t.Fatal(err)
+		}
+		return chain
+	}
+
+	chain := newChain()
+	for i := 0; i < 2; i++ {
+		if err := chain.Route(node, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(node.forwarded) != 1 || len(store.seen) != 1 {
+		t.Fatalf("expected one tee and one recorded nonce, got %d and %d", len(node.forwarded), len(store.seen))
+	}
+	// restart
+	chain = newChain()
+	if err := chain.Route(node, message); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.forwarded) != 1 {
+		t.Fatal("tee ran again for a message seen before the restart")
+	}
+}
+
+func Test_Chain_Tee_Replay_1(t *testing.T) {
+
+	node := new(forwardNode)
+	message := encodeMessage(api.Msg{Name: "ops", IsChan: true, Content: bytes.NewBuffer(bytes.Repeat([]byte{7}, nonceSize+16))})
+	chain := NewChainRouter(nil, NewTeeFilter("ops", "archive", "audit"), NewDropFilter("ops"))
+	if err := chain.Route(node, message); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.forwarded) != 2 {
+		t.Fatal("expected two tee copies, got", len(node.forwarded))
+	}
+
+	// the next hop sees the original and both copies, none of them may look like a replay
+	store := new(memStore)
+	next := NewChainRouter(nil, NewDropFilter("ops", "archive", "audit"))
+	next.PersistReplay = true
+	if err := next.SetReplayStore(store); err != nil {
+		t.Fatal(err)
+	}
+	messages := [][]byte{message}
+	for _, tee := range node.forwarded {
+		if !tee.IsChan || tee.Content.Len() != nonceSize+16 {
+			t.Fatalf("bad tee copy: %+v", tee)
+		}
+		messages = append(messages, encodeMessage(tee))
+	}
+	for _, m := range messages {
+		if err := next.Route(node, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.seen) != 3 {
+		t.Fatal("next hop took", 3-len(store.seen), "tee copies for replays")
+	}
+}
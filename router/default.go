@@ -4,76 +4,23 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"hash/crc32"
-	"math"
-	"sort"
+	"path"
 	"sync"
+	"time"
 
 	"github.com/awgh/ratnet"
 	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
 )
 
-const (
-	cacheSize     = 4 * 1024
-	cacheDiscount = int(0.25 * cacheSize)
-	nonceSize     = 32
-)
-
-// RecentBuffer - Used for tracking recently seen messages
-type RecentBuffer struct {
-	mtx           sync.Mutex
-	recentBuffer  map[uint32]int
-	reverseBuffer map[int]uint32
-	counter       int
-}
-
-func newRecentBuffer() (r RecentBuffer) {
-	r.recentBuffer = make(map[uint32]int, cacheSize)
-	r.reverseBuffer = make(map[int]uint32, cacheSize)
-	r.counter = 0
-	return
-}
-
-// SeenRecently : Returns whether this message should be filtered out by loop detection
-func (r *RecentBuffer) SeenRecently(nonce []byte) bool {
-	r.mtx.Lock()
-	defer r.mtx.Unlock()
-	nonceHash := crc32.ChecksumIEEE(nonce)
-
-	_, seen := r.recentBuffer[nonceHash]
-	if !seen {
-		r.recentBuffer[nonceHash] = r.counter
-		r.reverseBuffer[r.counter] = nonceHash
-		if r.counter == math.MaxInt32 { // todo doc: cacheSize is limited to MaxInt32
-			r.counter = 0
-		} else {
-			r.counter++
-		}
-	}
-	// garbage collection
-	m := len(r.recentBuffer)
-	if m >= cacheSize {
-		values := make([]int, 0, m)
-		for _, v := range r.recentBuffer {
-			values = append(values, v)
-		}
-		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
-		discount := (cacheSize - m) + cacheDiscount
-		for i := 0; i < discount; i++ {
-			nh := r.reverseBuffer[values[i]]
-			delete(r.reverseBuffer, values[i])
-			delete(r.recentBuffer, nh)
-		}
-	}
-	return seen
-}
-
 // DefaultRouter - The Default router makes no changes at all,
 //                 every message is sent out on the same channel it came in on,
 //                 and non-channel messages are consumed but not forwarded
 type DefaultRouter struct {
 	// Internal
-	RecentBuffer
+	*ReplayCache // loop detection, sized by SetCapacity and SetTTL (ReplayCapacity and ReplayTTL in JSON)
+	patchMtx     sync.RWMutex
+	patchIndex   *patchIndex
 
 	Patches []api.Patch
 
@@ -99,6 +46,9 @@ type DefaultRouter struct {
 	ForwardUnknownChannels bool
 	// ForwardUnknownProfile - Should node forward non-consumed messages that matched a profile key
 	ForwardUnknownProfiles bool
+
+	// PersistReplay - Should loop detection state be saved by the node, so it survives a restart
+	PersistReplay bool
 }
 
 func init() {
@@ -106,8 +56,110 @@ func init() {
 }
 
 // NewRouterFromMap : Makes a new instance of this module from a map of arguments (for deserialization support)
-func NewRouterFromMap(r map[string]interface{}) api.Router {
-	return NewDefaultRouter()
+func NewRouterFromMap(r map[string]interface{}) (api.Router, error) {
+	router := NewDefaultRouter()
+	flags := map[string]*bool{
+		"CheckContent":            &router.CheckContent,
+		"CheckChannels":           &router.CheckChannels,
+		"CheckProfiles":           &router.CheckProfiles,
+		"ForwardConsumedContent":  &router.ForwardConsumedContent,
+		"ForwardConsumedChannels": &router.ForwardConsumedChannels,
+		"ForwardConsumedProfiles": &router.ForwardConsumedProfiles,
+		"ForwardUnknownContent":   &router.ForwardUnknownContent,
+		"ForwardUnknownChannels":  &router.ForwardUnknownChannels,
+		"ForwardUnknownProfiles":  &router.ForwardUnknownProfiles,
+		"PersistReplay":           &router.PersistReplay,
+	}
+	for k, v := range r {
+		if flag, ok := flags[k]; ok {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, errors.New("Router field " + k + " must be a bool")
+			}
+			*flag = b
+			continue
+		}
+		switch k {
+		case "Router":
+		case "ReplayCapacity":
+			capacity, ok := v.(float64)
+			if !ok || capacity < 0 {
+				return nil, errors.New("Router field ReplayCapacity must be a non-negative number")
+			}
+			router.SetCapacity(int(capacity))
+		case "ReplayTTL":
+			ttl, ok := v.(float64)
+			if !ok || ttl < 0 {
+				return nil, errors.New("Router field ReplayTTL must be a non-negative number of seconds")
+			}
+			router.SetTTL(time.Duration(ttl) * time.Second)
+		case "Patches":
+			if v == nil {
+				continue
+			}
+			patches, ok := v.([]interface{})
+			if !ok {
+				return nil, errors.New("Router field Patches must be an array")
+			}
+			for _, p := range patches {
+				patch, err := patchFromMap(p)
+				if err != nil {
+					return nil, err
+				}
+				router.Patch(patch)
+			}
+		default:
+			return nil, errors.New("Unknown Router field: " + k)
+		}
+	}
+	return router, nil
+}
+
+// patchFromMap - validates and converts one deserialized entry of Patches
+func patchFromMap(v interface{}) (api.Patch, error) {
+	var patch api.Patch
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return patch, errors.New("Router field Patches must contain objects")
+	}
+	if patch.From, ok = m["From"].(string); !ok {
+		return patch, errors.New("Patch field From must be a string")
+	}
+	var err error
+	if patch.To, err = stringsFromMap(m, "To"); err != nil {
+		return patch, err
+	}
+	if _, err := path.Match(patch.From, ""); err != nil {
+		return patch, errors.New("Patch field From is not a valid pattern: " + patch.From)
+	}
+	if patch.Exclude, err = stringsFromMap(m, "Exclude"); err != nil {
+		return patch, err
+	}
+	for _, e := range patch.Exclude {
+		if _, err := path.Match(e, ""); err != nil {
+			return patch, errors.New("Patch field Exclude has an invalid pattern: " + e)
+		}
+	}
+	return patch, nil
+}
+
+// stringsFromMap - reads an optional array of strings from a deserialized JSON object
+func stringsFromMap(m map[string]interface{}, key string) ([]string, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("Patch field " + key + " must be an array of strings")
+	}
+	out := make([]string, len(a))
+	for i := range a {
+		if out[i], ok = a[i].(string); !ok {
+			return nil, errors.New("Patch field " + key + " must be an array of strings")
+		}
+	}
+	return out, nil
 }
 
 // NewDefaultRouter - returns a new instance of DefaultRouter
@@ -122,37 +174,69 @@ func NewDefaultRouter() *DefaultRouter {
 	r.ForwardConsumedContent = false
 	r.ForwardConsumedChannels = true
 	r.ForwardConsumedProfiles = false
-	// init page maps
-	r.RecentBuffer = newRecentBuffer()
+	// init loop detection
+	r.ReplayCache = NewReplayCache(defaultReplayCapacity, defaultReplayTTL)
 	return r
 }
 
 // Patch : Redirect messages from one input to different outputs
 func (r *DefaultRouter) Patch(patch api.Patch) {
+	r.patchMtx.Lock()
+	defer r.patchMtx.Unlock()
 	r.Patches = append(r.Patches, patch)
+	r.patchIndex = newPatchIndex(r.Patches)
 }
 
 // GetPatches : Returns an array with the mappings of incoming channels to destination channels
 func (r *DefaultRouter) GetPatches() []api.Patch {
+	r.patchMtx.RLock()
+	defer r.patchMtx.RUnlock()
 	return r.Patches
 }
 
+// lookupPatch - finds the patch for an incoming channel, rebuilding the index if Patches was set directly
+func (r *DefaultRouter) lookupPatch(channel string) (api.Patch, bool) {
+	r.patchMtx.RLock()
+	idx := r.patchIndex
+	stale := idx == nil || len(idx.patches) != len(r.Patches)
+	r.patchMtx.RUnlock()
+	if stale {
+		r.patchMtx.Lock()
+		idx = newPatchIndex(r.Patches)
+		r.patchIndex = idx
+		r.patchMtx.Unlock()
+	}
+	return idx.lookup(channel)
+}
+
+// SetReplayStore : Reload previously seen nonces from the store and record new ones to it,
+// does nothing unless PersistReplay is set
+func (r *DefaultRouter) SetReplayStore(store api.ReplayStore) error {
+	if !r.PersistReplay {
+		return nil
+	}
+	return r.SetStore(store)
+}
+
 func (r *DefaultRouter) forward(node api.Node, msg api.Msg) error {
-	for _, p := range r.Patches { //todo: this could be constant-time
-		if msg.Name == p.From { // we don't check for IsChan here, we allow forwarding from "" chan to channels
-			for i := 0; i < len(p.To); i++ {
-				msg.Name = p.To[i]
-				if msg.Name == "" {
-					msg.IsChan = false
-				} else {
-					msg.IsChan = true
-				}
-				if err := node.Forward(msg); err != nil {
-					return err
-				}
+	msg, ok := nextHop(node, msg)
+	if !ok {
+		return nil
+	}
+	// we don't check for IsChan here, we allow forwarding from "" chan to channels
+	if p, ok := r.lookupPatch(msg.Name); ok {
+		for i := 0; i < len(p.To); i++ {
+			msg.Name = p.To[i]
+			if msg.Name == "" {
+				msg.IsChan = false
+			} else {
+				msg.IsChan = true
+			}
+			if err := node.Forward(msg); err != nil {
+				return err
 			}
-			return nil
 		}
+		return nil
 	}
 	if err := node.Forward(msg); err != nil {
 		return err
@@ -160,35 +244,87 @@ func (r *DefaultRouter) forward(node api.Node, msg api.Msg) error {
 	return nil
 }
 
-// Route - Router that does default behavior
-func (r *DefaultRouter) Route(node api.Node, message []byte) error {
+// nextHop - enforces the hop limit on a message about to be forwarded, and spends one hop of it;
+// messages without one are forwarded indefinitely
+func nextHop(node api.Node, msg api.Msg) (api.Msg, bool) {
+	if msg.HopLimit == 1 {
+		dropped(node, api.DropHopLimit, msg)
+		return msg, false
+	} else if msg.HopLimit > 1 {
+		msg.HopLimit--
+	}
+	return msg, true
+}
 
-	//  Stuff Everything will need just about every time...
-	//
+// dropped - reports a message the router discarded
+func dropped(node api.Node, reason string, msg api.Msg) {
+	events.Emit(node, api.Debug, api.MessageDropped, api.DropEvent{Reason: reason, Channel: msg.Name})
+}
+
+// parseMessage - splits a routed message into its flags, hop limit, channel name, and encrypted content
+func parseMessage(message []byte) (api.Msg, error) {
 	var msg api.Msg
+	if len(message) < 1 {
+		return msg, errors.New("Malformed message")
+	}
 	flags := message[0]
 	idx := 1
 	msg.IsChan = ((flags & api.ChannelFlag) != 0)
 	msg.Chunked = ((flags & api.ChunkedFlag) != 0)
 	msg.StreamHeader = ((flags & api.StreamHeaderFlag) != 0)
-	var channelLen uint16 // beginning uint16 of message is channel name length
+	msg.Receipt = ((flags & api.ReceiptFlag) != 0)
+	if (flags & api.HopLimitFlag) != 0 {
+		if len(message) < 2 || message[1] == 0 { // a message with no hops left is never sent
+			return msg, errors.New("Malformed message")
+		}
+		msg.HopLimit = message[1]
+		idx++
+	}
 	if msg.IsChan {
-		channelLen = (uint16(message[1]) << 8) | uint16(message[2])
-		msg.Name = string(message[3 : 3+channelLen]) // flags[0], chan name length[1,2]
-		idx += 2 + int(channelLen)                   // skip over the channel name
+		if len(message) < idx+2 {
+			return msg, errors.New("Malformed message")
+		}
+		channelLen := (uint16(message[idx]) << 8) | uint16(message[idx+1]) // uint16 of channel name length
+		idx += 2
+		if idx+int(channelLen) > len(message) {
+			return msg, errors.New("Malformed message")
+		}
+		msg.Name = string(message[idx : idx+int(channelLen)])
+		idx += int(channelLen) // skip over the channel name
 	}
-	if idx+16 >= len(message) {
-		return errors.New("Malformed message")
+	if idx+nonceSize > len(message) {
+		return msg, errors.New("Malformed message")
 	}
-	nonce := message[idx : idx+nonceSize]
+	msg.Content = bytes.NewBuffer(message[idx:])
+	return msg, nil
+}
+
+// Route - Router that does default behavior
+func (r *DefaultRouter) Route(node api.Node, message []byte) error {
+
+	//  Stuff Everything will need just about every time...
+	//
+	msg, err := parseMessage(message)
+	if err != nil {
+		dropped(node, api.DropMalformed, msg)
+		return err
+	}
+	nonce := msg.Content.Bytes()[:nonceSize]
 	if r.SeenRecently(nonce) { // LOOP PREVENTION before handling or forwarding
+		dropped(node, api.DropReplay, msg)
 		return nil
 	}
+	if err := r.Persist(nonce); err != nil {
+		events.Warning(node, "could not persist loop detection state: "+err.Error())
+	}
+	return r.route(node, msg)
+}
+
+func (r *DefaultRouter) route(node api.Node, msg api.Msg) error {
 	cid, err := node.CID() // we need this for cloning
 	if err != nil {
 		return err
 	}
-	msg.Content = bytes.NewBuffer(message[idx:])
 
 	// Routing Logic
 	if msg.IsChan { // channel message
@@ -269,5 +405,8 @@ func (r *DefaultRouter) MarshalJSON() (b []byte, e error) {
 		"CheckChannels":           r.CheckChannels,
 		"ForwardConsumedChannels": r.ForwardConsumedChannels,
 		"ForwardUnknownChannels":  r.ForwardUnknownChannels,
-		"Patches":                 r.Patches})
+		"ReplayCapacity":          r.Capacity(),
+		"ReplayTTL":               int64(r.TTL() / time.Second),
+		"PersistReplay":           r.PersistReplay,
+		"Patches":                 r.GetPatches()})
 }
@@ -0,0 +1,160 @@
+package router
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/awgh/ratnet"
+	"github.com/awgh/ratnet/api"
+)
+
+func Test_Router_JSON_RoundTrip_1(t *testing.T) {
+
+	r := NewDefaultRouter()
+	r.CheckContent = false
+	r.CheckProfiles = true
+	r.ForwardConsumedContent = true
+	r.ForwardUnknownChannels = false
+	r.PersistReplay = true
+	r.SetCapacity(100)
+	r.SetTTL(30 * time.Second)
+	r.Patch(api.Patch{From: "a", To: []string{"b"}})
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ratnet.NewRouterFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := json.Marshal(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Fatalf("router did not round-trip:\n%s\n%s", b, b2)
+	}
+}
+
+func Test_Router_JSON_Invalid_1(t *testing.T) {
+
+	invalid := []string{
+		`{}`,
+		`{"Router":"nonesuch"}`,
+		`{"Router":"default","CheckContent":"yes"}`,
+		`{"Router":"default","ReplayTTL":-1}`,
+		`{"Router":"default","Patches":{"From":"a"}}`,
+		`{"Router":"default","Patches":[{"To":["b"]}]}`,
+		`{"Router":"default","Patches":[{"From":"a","To":[1]}]}`,
+		`{"Router":"default","Patches":[{"From":"[a","To":["b"]}]}`,
+		`{"Router":"default","CheckChanels":true}`,
+		`{"Router":"chain","Filters":[{"Filter":"nonesuch"}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"ratelimit","Limit":"10","Interval":1000}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"ratelimit","Limit":10}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"ratelimit","Limit":-1,"Interval":1000}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"ratelimit","Limit":1.5,"Interval":1000}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"ratelimit","Limit":10,"Interval":0}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"ratelimit","Limit":10,"Interval":1000,"Burst":5}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"drop","Channels":"spam"}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"drop","Channels":[1]}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"transform","Rename":{"a":1}}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"tee","From":1,"To":["b"]}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"tee","To":["b"]}]}`,
+		`{"Router":"chain","Filters":[{"Filter":"tee","From":"a","To":["b"],"Copies":2}]}`,
+		`{"Router":"chain","Next":{"Router":"default","CheckContent":1}}`,
+	}
+	for _, s := range invalid {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ratnet.NewRouterFromMap(m); err == nil {
+			t.Error("invalid router config was accepted:", s)
+		}
+	}
+}
+
+// forwardNode - records forwarded messages, any other Node method but Events panics
+type forwardNode struct {
+	api.Node
+	forwarded []api.Msg
+}
+
+// Events : events are not recorded, a nil channel is never ready so they are discarded
+func (n *forwardNode) Events() chan api.Event {
+	return nil
+}
+
+func (n *forwardNode) Forward(msg api.Msg) error {
+	n.forwarded = append(n.forwarded, msg)
+	return nil
+}
+
+// RecryptChannel : stands in for encrypting again, the content gets a fresh nonce
+func (n *forwardNode) RecryptChannel(from, to string, content []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return append(nonce, content[nonceSize:]...), nil
+}
+
+func Test_Router_HopLimit_1(t *testing.T) {
+
+	r := NewDefaultRouter()
+	tests := []struct{ in, out uint8 }{
+		{0, 0}, // no limit, forwarded unchanged
+		{3, 2},
+		{2, 1},
+		{1, 0}, // last hop, not forwarded
+	}
+	for _, tc := range tests {
+		node := new(forwardNode)
+		if err := r.forward(node, api.Msg{Name: "chat", IsChan: true, HopLimit: tc.in}); err != nil {
+			t.Fatal(err)
+		}
+		if tc.in == 1 {
+			if len(node.forwarded) != 0 {
+				t.Error("message with no hops left was forwarded")
+			}
+			continue
+		}
+		if len(node.forwarded) != 1 || node.forwarded[0].HopLimit != tc.out {
+			t.Errorf("hop limit %d forwarded as %+v, expected %d", tc.in, node.forwarded, tc.out)
+		}
+	}
+}
+
+func Test_Router_HopLimit_Tee_1(t *testing.T) {
+
+	tee := NewTeeFilter("ops", "archive")
+	tests := []struct{ in, out uint8 }{
+		{0, 0},
+		{3, 2},
+		{1, 0}, // last hop, no copy
+	}
+	for _, tc := range tests {
+		node := new(forwardNode)
+		content := bytes.NewBuffer(bytes.Repeat([]byte{3}, nonceSize+16))
+		if _, _, err := tee.Filter(node, api.Msg{Name: "ops", IsChan: true, HopLimit: tc.in, Content: content}); err != nil {
+			t.Fatal(err)
+		}
+		if tc.in == 1 {
+			if len(node.forwarded) != 0 {
+				t.Error("tee copy with no hops left was forwarded")
+			}
+			continue
+		}
+		if len(node.forwarded) != 1 || node.forwarded[0].HopLimit != tc.out {
+			t.Errorf("hop limit %d teed as %+v, expected %d", tc.in, node.forwarded, tc.out)
+		}
+	}
+}
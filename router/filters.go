@@ -0,0 +1,301 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/awgh/ratnet"
+	"github.com/awgh/ratnet/api"
+	"github.com/awgh/ratnet/api/events"
+)
+
+func init() {
+	// register these modules by name (for deserialization support)
+	ratnet.Filters["drop"] = NewDropFilterFromMap
+	ratnet.Filters["ratelimit"] = NewRateLimitFilterFromMap
+	ratnet.Filters["transform"] = NewTransformFilterFromMap
+	ratnet.Filters["tee"] = NewTeeFilterFromMap
+}
+
+// checkFilterFields - rejects any field of a filter config that is not "Filter" or one of the given names
+func checkFilterFields(f map[string]interface{}, fields ...string) error {
+	for k := range f {
+		known := k == "Filter"
+		for _, field := range fields {
+			known = known || k == field
+		}
+		if !known {
+			return errors.New("Unknown Filter field: " + k)
+		}
+	}
+	return nil
+}
+
+// filterStrings - converts a deserialized JSON array of strings, a missing or null field is empty
+func filterStrings(f map[string]interface{}, key string) ([]string, error) {
+	v, ok := f[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("Filter field " + key + " must be an array of strings")
+	}
+	out := make([]string, len(a))
+	for i := range a {
+		if out[i], ok = a[i].(string); !ok {
+			return nil, errors.New("Filter field " + key + " must be an array of strings")
+		}
+	}
+	return out, nil
+}
+
+// filterInt - converts a required, deserialized JSON number to an int of at least min
+func filterInt(f map[string]interface{}, key string, min int) (int, error) {
+	n, ok := f[key].(float64)
+	if !ok || n != math.Trunc(n) || n < float64(min) || n > math.MaxInt32 {
+		return 0, errors.New("Filter field " + key + " must be a whole number of at least " + strconv.Itoa(min))
+	}
+	return int(n), nil
+}
+
+// DropFilter - drops every message on one of the given channels ("" is the content/private channel)
+type DropFilter struct {
+	Channels []string
+}
+
+// NewDropFilterFromMap : Makes a new instance of this module from a map of arguments (for deserialization support)
+func NewDropFilterFromMap(f map[string]interface{}) (api.Filter, error) {
+	if err := checkFilterFields(f, "Channels"); err != nil {
+		return nil, err
+	}
+	channels, err := filterStrings(f, "Channels")
+	if err != nil {
+		return nil, err
+	}
+	return NewDropFilter(channels...), nil
+}
+
+// NewDropFilter - returns a new instance of DropFilter
+func NewDropFilter(channels ...string) *DropFilter {
+	f := new(DropFilter)
+	f.Channels = channels
+	return f
+}
+
+// Filter : Drops the message if it is on one of the configured channels
+func (f *DropFilter) Filter(node api.Node, msg api.Msg) (api.Msg, bool, error) {
+	for _, c := range f.Channels {
+		if c == msg.Name {
+			return msg, false, nil
+		}
+	}
+	return msg, true, nil
+}
+
+// MarshalJSON : Create a serialized JSON blob out of the config of this filter
+func (f *DropFilter) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Filter":   "drop",
+		"Channels": f.Channels})
+}
+
+// RateLimitFilter - drops messages beyond Limit per Interval milliseconds,
+// counted separately for each incoming channel
+// (routed messages carry no sender identity other than their channel)
+type RateLimitFilter struct {
+	mtx       sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time // windows idle for a whole interval are evicted at most once per interval
+
+	Limit    int
+	Interval int
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimitFilterFromMap : Makes a new instance of this module from a map of arguments (for deserialization support)
+func NewRateLimitFilterFromMap(f map[string]interface{}) (api.Filter, error) {
+	if err := checkFilterFields(f, "Limit", "Interval"); err != nil {
+		return nil, err
+	}
+	limit, err := filterInt(f, "Limit", 0)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := filterInt(f, "Interval", 1)
+	if err != nil {
+		return nil, err
+	}
+	return NewRateLimitFilter(limit, interval), nil
+}
+
+// NewRateLimitFilter - returns a new instance of RateLimitFilter
+func NewRateLimitFilter(limit, interval int) *RateLimitFilter {
+	f := new(RateLimitFilter)
+	f.Limit = limit
+	f.Interval = interval
+	f.windows = make(map[string]*rateWindow)
+	return f
+}
+
+// Filter : Drops the message if its channel has already hit the limit for the current interval
+func (f *RateLimitFilter) Filter(node api.Node, msg api.Msg) (api.Msg, bool, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	now := time.Now()
+	interval := time.Duration(f.Interval) * time.Millisecond
+	if now.Sub(f.lastSweep) >= interval {
+		for name, w := range f.windows {
+			if now.Sub(w.start) >= interval {
+				delete(f.windows, name)
+			}
+		}
+		f.lastSweep = now
+	}
+	w, ok := f.windows[msg.Name]
+	if !ok || now.Sub(w.start) >= interval {
+		w = &rateWindow{start: now}
+		f.windows[msg.Name] = w
+	}
+	w.count++
+	return msg, w.count <= f.Limit, nil
+}
+
+// MarshalJSON : Create a serialized JSON blob out of the config of this filter
+func (f *RateLimitFilter) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Filter":   "ratelimit",
+		"Limit":    f.Limit,
+		"Interval": f.Interval})
+}
+
+// TransformFilter - renames the channel of matching messages, a new name of "" makes it a non-channel message
+type TransformFilter struct {
+	Rename map[string]string
+}
+
+// NewTransformFilterFromMap : Makes a new instance of this module from a map of arguments (for deserialization support)
+func NewTransformFilterFromMap(f map[string]interface{}) (api.Filter, error) {
+	if err := checkFilterFields(f, "Rename"); err != nil {
+		return nil, err
+	}
+	rename := make(map[string]string)
+	if v, ok := f["Rename"]; ok && v != nil {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("Filter field Rename must be an object of strings")
+		}
+		for k, v := range m {
+			if rename[k], ok = v.(string); !ok {
+				return nil, errors.New("Filter field Rename must be an object of strings")
+			}
+		}
+	}
+	return NewTransformFilter(rename), nil
+}
+
+// NewTransformFilter - returns a new instance of TransformFilter
+func NewTransformFilter(rename map[string]string) *TransformFilter {
+	f := new(TransformFilter)
+	f.Rename = rename
+	if f.Rename == nil {
+		f.Rename = make(map[string]string)
+	}
+	return f
+}
+
+// Filter : Renames the channel of the message, if it has a mapping
+func (f *TransformFilter) Filter(node api.Node, msg api.Msg) (api.Msg, bool, error) {
+	if to, ok := f.Rename[msg.Name]; ok {
+		msg.Name = to
+		msg.IsChan = to != ""
+	}
+	return msg, true, nil
+}
+
+// MarshalJSON : Create a serialized JSON blob out of the config of this filter
+func (f *TransformFilter) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Filter": "transform",
+		"Rename": f.Rename})
+}
+
+// TeeFilter - forwards a copy of every message on channel From to each of the To channels,
+// the original continues down the chain unchanged; the node needs the keys of all of them,
+// since each copy is encrypted again to its channel's key so that channel's subscribers can read it
+type TeeFilter struct {
+	From string
+	To   []string
+}
+
+// NewTeeFilterFromMap : Makes a new instance of this module from a map of arguments (for deserialization support)
+func NewTeeFilterFromMap(f map[string]interface{}) (api.Filter, error) {
+	if err := checkFilterFields(f, "From", "To"); err != nil {
+		return nil, err
+	}
+	from, ok := f["From"].(string)
+	if !ok {
+		return nil, errors.New("Filter field From must be a string")
+	}
+	to, err := filterStrings(f, "To")
+	if err != nil {
+		return nil, err
+	}
+	return NewTeeFilter(from, to...), nil
+}
+
+// NewTeeFilter - returns a new instance of TeeFilter
+func NewTeeFilter(from string, to ...string) *TeeFilter {
+	f := new(TeeFilter)
+	f.From = from
+	f.To = to
+	return f
+}
+
+// Filter : Forwards copies of the message to the extra channels, encrypted again for each of them,
+// which also gives each copy a loop detection nonce of its own; each copy spends a hop like any forwarded message
+func (f *TeeFilter) Filter(node api.Node, msg api.Msg) (api.Msg, bool, error) {
+	if !msg.IsChan || msg.Name != f.From {
+		return msg, true, nil
+	}
+	recrypter, ok := node.(api.ChannelRecrypter)
+	if !ok {
+		events.Warning(node, "tee needs a node that can encrypt messages again for another channel")
+		return msg, true, nil
+	}
+	for _, to := range f.To {
+		content, err := recrypter.RecryptChannel(f.From, to, msg.Content.Bytes())
+		if err != nil {
+			events.Warning(node, "tee from "+f.From+" to "+to+" failed: "+err.Error())
+			continue
+		}
+		tee := msg
+		tee.Name = to
+		tee.IsChan = true
+		tee.Content = bytes.NewBuffer(content)
+		if tee, ok = nextHop(node, tee); !ok {
+			continue
+		}
+		if err := node.Forward(tee); err != nil {
+			return msg, false, err
+		}
+	}
+	return msg, true, nil
+}
+
+// MarshalJSON : Create a serialized JSON blob out of the config of this filter
+func (f *TeeFilter) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Filter": "tee",
+		"From":   f.From,
+		"To":     f.To})
+}
@@ -2,13 +2,14 @@ package router
 
 import (
 	"testing"
+	"time"
 
 	"github.com/awgh/bencrypt/bc"
 )
 
 func Test_Loop_OneMessage_1(t *testing.T) {
 
-	recentBuffer := newRecentBuffer()
+	recentBuffer := NewReplayCache(0, 0)
 	b, err := bc.GenerateRandomBytes(nonceSize)
 	if err != nil {
 		t.Fatal(err)
@@ -28,7 +29,7 @@ func Test_Loop_OneMessage_1(t *testing.T) {
 
 func Test_Loop_Random_1(t *testing.T) {
 
-	recentBuffer := newRecentBuffer()
+	recentBuffer := NewReplayCache(0, 0)
 	for i := 0; i < 100000; i++ {
 		b, err := bc.GenerateRandomBytes(nonceSize)
 		if err != nil {
@@ -44,7 +45,7 @@ func Test_Loop_Random_1(t *testing.T) {
 
 func Test_Loop_Fixed_1(t *testing.T) {
 
-	recentBuffer := newRecentBuffer()
+	recentBuffer := NewReplayCache(0, 0)
 	var sendBuffers [][]byte
 	for i := 0; i < 1000; i++ {
 		b, err := bc.GenerateRandomBytes(nonceSize)
@@ -65,3 +66,63 @@ func Test_Loop_Fixed_1(t *testing.T) {
 		t.Fatal("SeenRecently never returned true on fixed loop test")
 	}
 }
+
+func Test_Loop_Expiry_1(t *testing.T) {
+
+	recentBuffer := NewReplayCache(0, 50*time.Millisecond)
+	b, err := bc.GenerateRandomBytes(nonceSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recentBuffer.SeenRecently(b) {
+		t.Fatal("SeenRecently returned true on first sighting")
+	}
+	if !recentBuffer.SeenRecently(b) {
+		t.Fatal("SeenRecently returned false on second sighting")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if recentBuffer.SeenRecently(b) {
+		t.Fatal("SeenRecently returned true after the TTL expired")
+	}
+	stats := recentBuffer.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Expirations != 1 || stats.Size != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func Test_Loop_Capacity_1(t *testing.T) {
+
+	recentBuffer := NewReplayCache(100, 0)
+	var sendBuffers [][]byte
+	for i := 0; i < 150; i++ {
+		b, err := bc.GenerateRandomBytes(nonceSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sendBuffers = append(sendBuffers, b)
+		recentBuffer.SeenRecently(b)
+	}
+	stats := recentBuffer.Stats()
+	if stats.Size != 100 || stats.Evictions != 50 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if recentBuffer.SeenRecently(sendBuffers[0]) {
+		t.Fatal("evicted nonce was still reported as seen")
+	}
+	if !recentBuffer.SeenRecently(sendBuffers[149]) {
+		t.Fatal("newest nonce was not reported as seen")
+	}
+}
+
+func Test_Loop_Collision_1(t *testing.T) {
+
+	// nonces that differ only after the first bytes must not collide
+	recentBuffer := NewReplayCache(0, 0)
+	a := make([]byte, nonceSize)
+	b := make([]byte, nonceSize)
+	b[nonceSize-1] = 1
+	recentBuffer.SeenRecently(a)
+	if recentBuffer.SeenRecently(b) {
+		t.Fatal("distinct nonces collided")
+	}
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"path"
+	"strings"
+
+	"github.com/awgh/ratnet/api"
+)
+
+// patchIndex - lookup tables over a list of patches, so finding the patch for a channel
+// does not depend on how many patches there are (except for glob patterns, which are scanned)
+type patchIndex struct {
+	patches  []api.Patch
+	exact    map[string][]int // From -> indices of patches with that exact name
+	prefixes map[string][]int // prefix (From without the trailing "*") -> indices
+	maxLen   int              // length of the longest prefix
+	globs    []int            // indices of patches using other glob syntax
+}
+
+// newPatchIndex - builds an index over the given patches, earlier patches win ties
+func newPatchIndex(patches []api.Patch) *patchIndex {
+	idx := &patchIndex{
+		patches:  patches,
+		exact:    make(map[string][]int),
+		prefixes: make(map[string][]int),
+	}
+	for i, p := range patches {
+		switch {
+		case !isGlob(p.From):
+			idx.exact[p.From] = append(idx.exact[p.From], i)
+		case isPrefix(p.From):
+			prefix := p.From[:len(p.From)-1]
+			idx.prefixes[prefix] = append(idx.prefixes[prefix], i)
+			if len(prefix) > idx.maxLen {
+				idx.maxLen = len(prefix)
+			}
+		default:
+			idx.globs = append(idx.globs, i)
+		}
+	}
+	return idx
+}
+
+// lookup - returns the patch for the given channel: an exact match first, then the longest prefix,
+// then glob patterns in the order they were added, skipping patches that exclude the channel
+func (idx *patchIndex) lookup(channel string) (api.Patch, bool) {
+	for _, i := range idx.exact[channel] {
+		if !excluded(idx.patches[i], channel) {
+			return idx.patches[i], true
+		}
+	}
+	n := len(channel)
+	if n > idx.maxLen {
+		n = idx.maxLen
+	}
+	for ; n >= 0; n-- {
+		for _, i := range idx.prefixes[channel[:n]] {
+			if !excluded(idx.patches[i], channel) {
+				return idx.patches[i], true
+			}
+		}
+	}
+	for _, i := range idx.globs {
+		if ok, _ := path.Match(idx.patches[i].From, channel); ok && !excluded(idx.patches[i], channel) {
+			return idx.patches[i], true
+		}
+	}
+	return api.Patch{}, false
+}
+
+// excluded - returns whether the channel matches one of the Exclude patterns of the patch
+func excluded(p api.Patch, channel string) bool {
+	for _, e := range p.Exclude {
+		if matchPattern(e, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern - matches a channel against a patch pattern: exact, prefix ending in "*", or glob
+func matchPattern(pattern, channel string) bool {
+	switch {
+	case !isGlob(pattern):
+		return pattern == channel
+	case isPrefix(pattern):
+		return strings.HasPrefix(channel, pattern[:len(pattern)-1])
+	}
+	ok, _ := path.Match(pattern, channel)
+	return ok
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// isPrefix - a pattern whose only special character is a trailing "*"
+func isPrefix(pattern string) bool {
+	return strings.HasSuffix(pattern, "*") && !isGlob(pattern[:len(pattern)-1])
+}
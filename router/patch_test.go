@@ -0,0 +1,69 @@
+package router
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/awgh/ratnet"
+	"github.com/awgh/ratnet/api"
+)
+
+func Test_Patch_Lookup_1(t *testing.T) {
+
+	r := NewDefaultRouter()
+	r.Patch(api.Patch{From: "ops.*", To: []string{"archive"}, Exclude: []string{"ops.secret*"}})
+	r.Patch(api.Patch{From: "ops.alerts.*", To: []string{"pager"}})
+	r.Patch(api.Patch{From: "ops.deploy", To: []string{"deploys"}})
+	r.Patch(api.Patch{From: "log-?", To: []string{"logs"}})
+	r.Patch(api.Patch{From: "", To: []string{"inbox"}})
+
+	tests := map[string][]string{
+		"ops.deploy":      {"deploys"}, // exact beats prefix
+		"ops.alerts.disk": {"pager"},   // longest prefix wins
+		"ops.misc":        {"archive"},
+		"ops.secret.keys": nil, // excluded
+		"log-1":           {"logs"},
+		"log-12":          nil,
+		"":                {"inbox"},
+		"chat":            nil,
+	}
+	for channel, to := range tests {
+		p, ok := r.lookupPatch(channel)
+		if to == nil {
+			if ok {
+				t.Errorf("channel %q should not be patched, got %+v", channel, p)
+			}
+			continue
+		}
+		if !ok || !reflect.DeepEqual(p.To, to) {
+			t.Errorf("channel %q patched to %v, expected %v", channel, p.To, to)
+		}
+	}
+}
+
+func Test_Patch_JSON_RoundTrip_1(t *testing.T) {
+
+	r := NewDefaultRouter()
+	r.Patch(api.Patch{From: "ops.*", To: []string{"archive", "audit"}, Exclude: []string{"ops.secret"}})
+	r.Patch(api.Patch{From: "chat", To: []string{""}})
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ratnet.NewRouterFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.GetPatches(), r.GetPatches()) {
+		t.Fatalf("patches did not round-trip:\n%+v\n%+v", r.GetPatches(), restored.GetPatches())
+	}
+	if p, ok := restored.(*DefaultRouter).lookupPatch("ops.misc"); !ok || p.From != "ops.*" {
+		t.Fatal("restored router did not index its patches")
+	}
+}
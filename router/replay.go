@@ -0,0 +1,206 @@
+package router
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/awgh/ratnet/api"
+)
+
+const (
+	defaultReplayCapacity = 32 * 1024
+	defaultReplayTTL      = 10 * time.Minute
+	replayFlushInterval   = time.Minute // how often expired nonces are deleted from a ReplayStore
+	nonceSize             = 32
+)
+
+type replayEntry struct {
+	nonce [nonceSize]byte
+	seen  time.Time
+}
+
+// ReplayStats - counters describing the behavior of a ReplayCache
+type ReplayStats struct {
+	Size        int    // nonces currently held
+	Hits        uint64 // messages dropped as already seen
+	Misses      uint64 // messages seen for the first time
+	Evictions   uint64 // nonces dropped early because the cache was full
+	Expirations uint64 // nonces dropped because they outlived the TTL
+}
+
+// ReplayCache - Used for tracking recently seen messages by their full nonce,
+// entries are forgotten after the TTL or, oldest first, when the capacity is reached
+type ReplayCache struct {
+	mtx     sync.Mutex
+	entries map[[nonceSize]byte]*list.Element
+	order   *list.List // oldest entry at the front
+
+	capacity int
+	ttl      time.Duration
+	stats    ReplayStats
+
+	store     api.ReplayStore
+	lastFlush time.Time
+}
+
+// NewReplayCache - returns a new ReplayCache, non-positive arguments select the defaults
+func NewReplayCache(capacity int, ttl time.Duration) *ReplayCache {
+	r := new(ReplayCache)
+	r.entries = make(map[[nonceSize]byte]*list.Element)
+	r.order = list.New()
+	r.SetCapacity(capacity)
+	r.SetTTL(ttl)
+	return r
+}
+
+// Capacity : Returns the maximum number of nonces held
+func (r *ReplayCache) Capacity() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.capacity
+}
+
+// SetCapacity : Sets the maximum number of nonces held, evicting the oldest if needed
+func (r *ReplayCache) SetCapacity(capacity int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if capacity <= 0 {
+		capacity = defaultReplayCapacity
+	}
+	r.capacity = capacity
+	for r.order.Len() > r.capacity {
+		r.remove(r.order.Front())
+		r.stats.Evictions++
+	}
+}
+
+// TTL : Returns how long a nonce is remembered
+func (r *ReplayCache) TTL() time.Duration {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.ttl
+}
+
+// SetTTL : Sets how long a nonce is remembered
+func (r *ReplayCache) SetTTL(ttl time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if ttl <= 0 {
+		ttl = defaultReplayTTL
+	}
+	r.ttl = ttl
+}
+
+// Stats : Returns a snapshot of the counters for this cache
+func (r *ReplayCache) Stats() ReplayStats {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	stats := r.stats
+	stats.Size = r.order.Len()
+	return stats
+}
+
+// SeenRecently : Returns whether this message should be filtered out by loop detection
+func (r *ReplayCache) SeenRecently(nonce []byte) bool {
+	var key [nonceSize]byte
+	copy(key[:], nonce)
+	now := time.Now()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.expire(now)
+	if _, ok := r.entries[key]; ok {
+		r.stats.Hits++
+		return true
+	}
+	r.stats.Misses++
+	r.entries[key] = r.order.PushBack(&replayEntry{nonce: key, seen: now})
+	for r.order.Len() > r.capacity {
+		r.remove(r.order.Front())
+		r.stats.Evictions++
+	}
+	return false
+}
+
+// expire - drops entries older than the TTL, they are kept in the order they were first seen
+func (r *ReplayCache) expire(now time.Time) {
+	cutoff := now.Add(-r.ttl)
+	for e := r.order.Front(); e != nil && e.Value.(*replayEntry).seen.Before(cutoff); e = r.order.Front() {
+		r.remove(e)
+		r.stats.Expirations++
+	}
+}
+
+// SetStore : Loads the unexpired nonces from the given store, and from then on
+// Persist will record new nonces to it
+func (r *ReplayCache) SetStore(store api.ReplayStore) error {
+	now := time.Now()
+	cutoff := now.Add(-r.TTL()).UnixNano()
+	if err := store.FlushSeenNonces(cutoff); err != nil {
+		return err
+	}
+	seen, err := store.GetSeenNonces(cutoff)
+	if err != nil {
+		return err
+	}
+	sort.Slice(seen, func(i, j int) bool { return seen[i].Timestamp < seen[j].Timestamp })
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, s := range seen {
+		var key [nonceSize]byte
+		copy(key[:], s.Nonce)
+		if _, ok := r.entries[key]; ok {
+			continue
+		}
+		entry := &replayEntry{nonce: key, seen: time.Unix(0, s.Timestamp)}
+		// keep the list in time order, in case this cache was already in use
+		e := r.order.Back()
+		for e != nil && e.Value.(*replayEntry).seen.After(entry.seen) {
+			e = e.Prev()
+		}
+		if e == nil {
+			r.entries[key] = r.order.PushFront(entry)
+		} else {
+			r.entries[key] = r.order.InsertAfter(entry, e)
+		}
+	}
+	for r.order.Len() > r.capacity {
+		r.remove(r.order.Front())
+		r.stats.Evictions++
+	}
+	r.store = store
+	r.lastFlush = now
+	return nil
+}
+
+// Persist : Records a newly seen nonce to the store, if there is one,
+// and periodically deletes the expired nonces from it
+func (r *ReplayCache) Persist(nonce []byte) error {
+	now := time.Now()
+	r.mtx.Lock()
+	store := r.store
+	flush := store != nil && now.Sub(r.lastFlush) >= replayFlushInterval
+	if flush {
+		r.lastFlush = now
+	}
+	cutoff := now.Add(-r.ttl).UnixNano()
+	r.mtx.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	if flush {
+		if err := store.FlushSeenNonces(cutoff); err != nil {
+			return err
+		}
+	}
+	return store.AddSeenNonce(nonce, now.UnixNano())
+}
+
+func (r *ReplayCache) remove(e *list.Element) {
+	delete(r.entries, e.Value.(*replayEntry).nonce)
+	r.order.Remove(e)
+}
@@ -2,17 +2,20 @@ package ratnet
 
 import (
 	"encoding/gob"
+	"errors"
 
 	"github.com/awgh/bencrypt/ecc"
 	"github.com/awgh/bencrypt/rsa"
 	"github.com/awgh/ratnet/api"
 )
 
-// One map for each type of types:  Routers, Connection Policies, and Transports
+// One map for each type of types:  Routers, Router Filters, Connection Policies, and Transports
 
 var (
 	// Routers : Registry of available Router modules by name
-	Routers map[string]func(map[string]interface{}) api.Router
+	Routers map[string]func(map[string]interface{}) (api.Router, error)
+	// Filters : Registry of available Router Filter modules by name
+	Filters map[string]func(map[string]interface{}) (api.Filter, error)
 	// Policies : Registry of available Policy modules by name
 	Policies map[string]func(api.Transport, api.Node, map[string]interface{}) api.Policy
 	// Transports : Registry of available Transport modules by name
@@ -20,7 +23,8 @@ var (
 )
 
 func init() {
-	Routers = make(map[string]func(map[string]interface{}) api.Router)
+	Routers = make(map[string]func(map[string]interface{}) (api.Router, error))
+	Filters = make(map[string]func(map[string]interface{}) (api.Filter, error))
 	Policies = make(map[string]func(api.Transport, api.Node, map[string]interface{}) api.Policy)
 	Transports = make(map[string]func(api.Node, map[string]interface{}) api.Transport)
 
@@ -46,9 +50,29 @@ func NewTransportFromMap(node api.Node, t map[string]interface{}) api.Transport
 }
 
 // NewRouterFromMap : Create a new instance of a Router from a map of arguments
-func NewRouterFromMap(r map[string]interface{}) api.Router {
-	rtype := r["Router"].(string)
-	return Routers[rtype](r)
+func NewRouterFromMap(r map[string]interface{}) (api.Router, error) {
+	rtype, ok := r["Router"].(string)
+	if !ok {
+		return nil, errors.New("Router type missing from config")
+	}
+	newRouter, ok := Routers[rtype]
+	if !ok {
+		return nil, errors.New("Unknown Router type: " + rtype)
+	}
+	return newRouter(r)
+}
+
+// NewFilterFromMap : Create a new instance of a Router Filter from a map of arguments
+func NewFilterFromMap(f map[string]interface{}) (api.Filter, error) {
+	ftype, ok := f["Filter"].(string)
+	if !ok {
+		return nil, errors.New("Filter type missing from config")
+	}
+	newFilter, ok := Filters[ftype]
+	if !ok {
+		return nil, errors.New("Unknown Filter type: " + ftype)
+	}
+	return newFilter(f)
 }
 
 // NewPolicyFromMap : Create a new instance of a Policy from a map of arguments